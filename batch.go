@@ -0,0 +1,335 @@
+package dropbox // nolint: golint
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dropbox/dropbox-sdk-go-unofficial/dropbox/async"
+	"github.com/dropbox/dropbox-sdk-go-unofficial/dropbox/files"
+)
+
+const (
+	batchPollMinDelay = 100 * time.Millisecond
+	batchPollMaxDelay = 5 * time.Second
+	// batchMaxEntries is the maximum number of entries of a batch accepted by dropbox
+	batchMaxEntries = 1000
+)
+
+// BatchRelocation describes one move or copy of a batch.
+type BatchRelocation struct {
+	From string
+	To   string
+}
+
+// BatchResult is the outcome of the operation on one entry of a batch.
+type BatchResult struct {
+	// Path of the entry, relative to the root directory
+	Path string
+	// Info describes the resulting file, when the operation succeeded
+	Info os.FileInfo
+	// Err is the error that happened on this entry
+	Err error
+}
+
+// RemoveBatch removes multiple files or directories at once.
+// Like RemoveAll, directories are removed with all their content.
+// The returned results are in the same order as the names.
+// Large batches are sent in several requests, the ones already sent aren't reverted if one fails:
+// their results are returned along with the error.
+func (fs *Fs) RemoveBatch(names []string) ([]*BatchResult, error) {
+	if len(names) == 0 {
+		return nil, ErrEmptyBatch
+	}
+
+	entries := make([]*files.DeleteArg, 0, len(names))
+
+	for _, name := range names {
+		p, err := fs.realPath("remove", name)
+		if err != nil {
+			return nil, err
+		}
+
+		if fs.isRootPath(p) {
+			return nil, &os.PathError{Op: "remove", Path: name, Err: ErrRootDirectory}
+		}
+
+		entries = append(entries, &files.DeleteArg{Path: p})
+	}
+
+	results := make([]*BatchResult, 0, len(names))
+
+	err := fs.splitBatch(len(names), func(start, end int) error {
+		chunk, errChunk := fs.removeBatch(names[start:end], &files.DeleteBatchArg{Entries: entries[start:end]})
+		results = append(results, chunk...)
+
+		return errChunk
+	})
+
+	return results, err
+}
+
+func (fs *Fs) removeBatch(names []string, arg *files.DeleteBatchArg) ([]*BatchResult, error) {
+	launch, err := fs.files.DeleteBatch(arg)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't start batch remove: %w", err)
+	}
+
+	res := launch.Complete
+
+	if launch.Tag == files.DeleteBatchLaunchAsyncJobId {
+		err = pollJob(func() (bool, error) {
+			status, errCheck := fs.files.DeleteBatchCheck(&async.PollArg{AsyncJobId: launch.AsyncJobId})
+			if errCheck != nil {
+				return false, fmt.Errorf("couldn't check batch remove: %w", errCheck)
+			}
+
+			switch status.Tag {
+			case files.DeleteBatchJobStatusInProgress:
+				return false, nil
+			case files.DeleteBatchJobStatusComplete:
+				res = status.Complete
+
+				return true, nil
+			default:
+				return false, fmt.Errorf("%w: %s", ErrBatchFailed, unionSummary(status))
+			}
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if res == nil || len(res.Entries) != len(names) {
+		return nil, fmt.Errorf("%w: unexpected result", ErrBatchFailed)
+	}
+
+	results := make([]*BatchResult, len(names))
+
+	for i, entry := range res.Entries {
+		results[i] = &BatchResult{Path: names[i]}
+
+		if entry.Tag == files.DeleteBatchResultEntrySuccess && entry.Success != nil {
+			results[i].Info = fs.newFileInfo(entry.Success.Metadata)
+		} else {
+			results[i].Err = batchEntryError("remove", names[i], unionSummary(entry.Failure))
+		}
+	}
+
+	return results, nil
+}
+
+// RenameBatch moves multiple files or directories at once.
+// The returned results are in the same order as the relocations.
+// Large batches are sent in several requests, the ones already sent aren't reverted if one fails:
+// their results are returned along with the error.
+func (fs *Fs) RenameBatch(relocations []BatchRelocation) ([]*BatchResult, error) {
+	return fs.relocationBatch("rename", relocations,
+		func(chunk []BatchRelocation, arg *files.RelocationBatchArgBase) ([]*BatchResult, error) {
+			launch, err := fs.files.MoveBatchV2(&files.MoveBatchArg{RelocationBatchArgBase: *arg})
+			if err != nil {
+				return nil, fmt.Errorf("couldn't start batch rename: %w", err)
+			}
+
+			return fs.relocationBatchResults("rename", chunk, launch, fs.files.MoveBatchCheckV2)
+		})
+}
+
+// CopyBatch copies multiple files or directories at once.
+// The returned results are in the same order as the relocations.
+// Large batches are sent in several requests, the ones already sent aren't reverted if one fails:
+// their results are returned along with the error.
+func (fs *Fs) CopyBatch(relocations []BatchRelocation) ([]*BatchResult, error) {
+	return fs.relocationBatch("copy", relocations,
+		func(chunk []BatchRelocation, arg *files.RelocationBatchArgBase) ([]*BatchResult, error) {
+			launch, err := fs.files.CopyBatchV2(arg)
+			if err != nil {
+				return nil, fmt.Errorf("couldn't start batch copy: %w", err)
+			}
+
+			return fs.relocationBatchResults("copy", chunk, launch, fs.files.CopyBatchCheckV2)
+		})
+}
+
+// relocationBatch sends the relocations in batches small enough for dropbox.
+func (fs *Fs) relocationBatch(
+	op string,
+	relocations []BatchRelocation,
+	send func(chunk []BatchRelocation, arg *files.RelocationBatchArgBase) ([]*BatchResult, error),
+) ([]*BatchResult, error) {
+	if len(relocations) == 0 {
+		return nil, ErrEmptyBatch
+	}
+
+	entries, err := fs.relocationBatchEntries(op, relocations)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*BatchResult, 0, len(relocations))
+
+	err = fs.splitBatch(len(relocations), func(start, end int) error {
+		chunk, errSend := send(relocations[start:end], &files.RelocationBatchArgBase{Entries: entries[start:end]})
+		results = append(results, chunk...)
+
+		return errSend
+	})
+
+	return results, err
+}
+
+// splitBatch calls send with the ranges of the batches of n entries.
+func (fs *Fs) splitBatch(n int, send func(start, end int) error) error {
+	for start := 0; start < n; start += fs.batchSize {
+		end := start + fs.batchSize
+		if end > n {
+			end = n
+		}
+
+		if err := send(start, end); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (fs *Fs) relocationBatchEntries(op string, relocations []BatchRelocation) ([]*files.RelocationPath, error) {
+	entries := make([]*files.RelocationPath, 0, len(relocations))
+
+	for _, r := range relocations {
+		from, err := fs.realPath(op, r.From)
+		if err != nil {
+			return nil, err
+		}
+
+		to, err := fs.realPath(op, r.To)
+		if err != nil {
+			return nil, err
+		}
+
+		// The root can be copied somewhere else, but it can't be moved or replaced
+		if (op == "rename" && fs.isRootPath(from)) || fs.isRootPath(to) {
+			return nil, &os.LinkError{Op: op, Old: r.From, New: r.To, Err: ErrRootDirectory}
+		}
+
+		entries = append(entries, &files.RelocationPath{FromPath: from, ToPath: to})
+	}
+
+	return entries, nil
+}
+
+func (fs *Fs) relocationBatchResults(
+	op string,
+	relocations []BatchRelocation,
+	launch *files.RelocationBatchV2Launch,
+	check func(arg *async.PollArg) (*files.RelocationBatchV2JobStatus, error),
+) ([]*BatchResult, error) {
+	res := launch.Complete
+
+	if launch.Tag == files.RelocationBatchV2LaunchAsyncJobId {
+		err := pollJob(func() (bool, error) {
+			status, errCheck := check(&async.PollArg{AsyncJobId: launch.AsyncJobId})
+			if errCheck != nil {
+				return false, fmt.Errorf("couldn't check batch %s: %w", op, errCheck)
+			}
+
+			switch status.Tag {
+			case files.RelocationBatchV2JobStatusInProgress:
+				return false, nil
+			case files.RelocationBatchV2JobStatusComplete:
+				res = status.Complete
+
+				return true, nil
+			default:
+				return false, fmt.Errorf("%w: %s", ErrBatchFailed, unionSummary(status))
+			}
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if res == nil || len(res.Entries) != len(relocations) {
+		return nil, fmt.Errorf("%w: unexpected result", ErrBatchFailed)
+	}
+
+	results := make([]*BatchResult, len(relocations))
+
+	for i, entry := range res.Entries {
+		results[i] = &BatchResult{Path: relocations[i].To}
+
+		if entry.Tag == files.RelocationBatchResultEntrySuccess && entry.Success != nil {
+			results[i].Info = fs.newFileInfo(entry.Success)
+		} else {
+			results[i].Err = batchEntryError(op, relocations[i].From, unionSummary(entry.Failure))
+		}
+	}
+
+	return results, nil
+}
+
+// pollJob calls check with an increasing delay until the job is done.
+func pollJob(check func() (bool, error)) error {
+	delay := batchPollMinDelay
+
+	for {
+		done, err := check()
+		if err != nil || done {
+			return err
+		}
+
+		time.Sleep(delay)
+
+		if delay *= 2; delay > batchPollMaxDelay {
+			delay = batchPollMaxDelay
+		}
+	}
+}
+
+// unionSummary converts a tagged union to a summary like the API's "path_lookup/not_found".
+func unionSummary(union interface{}) string {
+	raw, err := json.Marshal(union)
+	if err != nil {
+		return ""
+	}
+
+	var tags []string
+
+	for {
+		var tagged map[string]json.RawMessage
+		if json.Unmarshal(raw, &tagged) != nil {
+			break
+		}
+
+		var tag string
+		if json.Unmarshal(tagged[".tag"], &tag) != nil || tag == "" {
+			break
+		}
+
+		tags = append(tags, tag)
+		raw = tagged[tag]
+	}
+
+	return strings.Join(tags, "/")
+}
+
+// batchEntryError converts the failure of an entry to an error that can be checked
+// with os.IsNotExist and os.IsExist.
+func batchEntryError(op, name, summary string) error {
+	err := errors.New(summary)
+
+	switch {
+	case strings.Contains(summary, "not_found"):
+		err = os.ErrNotExist
+	case strings.Contains(summary, "conflict"):
+		err = os.ErrExist
+	}
+
+	return &os.PathError{Op: op, Path: name, Err: err}
+}
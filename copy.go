@@ -0,0 +1,58 @@
+package dropbox // nolint: golint
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dropbox/dropbox-sdk-go-unofficial/dropbox/files"
+)
+
+// CopyOptions allows to change the behavior of a copy.
+type CopyOptions struct {
+	// Autorename makes the copy pick a different name instead of failing when the destination exists
+	Autorename bool
+	// AllowOwnershipTransfer allows copies that would change the ownership of the content
+	AllowOwnershipTransfer bool
+}
+
+// Copy copies a file or a directory with all its content.
+// The copy is performed on the server side, it doesn't transfer any data and is
+// pretty much instant even for large files and directories.
+// It returns the info of the copy, which might have been renamed if opts.Autorename is set.
+func (fs *Fs) Copy(src, dst string, opts *CopyOptions) (os.FileInfo, error) {
+	if opts == nil {
+		opts = &CopyOptions{}
+	}
+
+	from, err := fs.realPath("copy", src)
+	if err != nil {
+		return nil, err
+	}
+
+	to, err := fs.realPath("copy", dst)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := fs.files.CopyV2(&files.RelocationArg{
+		RelocationPath: files.RelocationPath{
+			FromPath: from,
+			ToPath:   to,
+		},
+		Autorename:             opts.Autorename,
+		AllowOwnershipTransfer: opts.AllowOwnershipTransfer,
+	})
+
+	if err != nil {
+		switch {
+		case isNotFound(err):
+			return nil, &os.PathError{Op: "copy", Path: src, Err: os.ErrNotExist}
+		case isConflict(err):
+			return nil, &os.PathError{Op: "copy", Path: dst, Err: os.ErrExist}
+		}
+
+		return nil, fmt.Errorf("couldn't copy file: %w", err)
+	}
+
+	return fs.newFileInfo(res.Metadata), nil
+}
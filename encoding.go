@@ -0,0 +1,147 @@
+package dropbox // nolint: golint
+
+import "strings"
+
+// NameEncoder converts the names to and from the names stored in dropbox.
+// It works on a single element of a path and must be reversible: Decode(Encode(name)) == name.
+type NameEncoder interface {
+	// Encode converts a name to a name that dropbox accepts
+	Encode(name string) string
+	// Decode converts a name stored in dropbox back to the original name
+	Decode(name string) string
+}
+
+const (
+	encodedControlStart = '␀' // control characters are mapped to the "Control Pictures" block
+	encodedDelete       = '␡'
+	encodedBackslash    = '＼'
+	encodedSpace        = '␠'
+	encodedDot          = '．'
+	encodedQuote        = '‛' // prefixes the characters that were already looking encoded
+)
+
+// StandardEncoder escapes the characters that dropbox doesn't accept in names by replacing them
+// with similar looking unicode characters, like rclone does:
+// - control characters are replaced by their "Control Pictures" (␀ to ␟ and ␡)
+// - backslashes are replaced by a fullwidth backslash (＼)
+// - a trailing space is replaced by ␠
+// - a trailing dot is replaced by a fullwidth dot (．)
+// Names that already contain one of the replacement characters have them prefixed with ‛,
+// so that they are restored as they are.
+type StandardEncoder struct{}
+
+func isEncodedRune(r rune) bool {
+	return (r >= encodedControlStart && r < encodedControlStart+0x20) ||
+		r == encodedDelete || r == encodedBackslash || r == encodedSpace || r == encodedDot || r == encodedQuote
+}
+
+// Encode converts a name to a name that dropbox accepts.
+func (StandardEncoder) Encode(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+
+	for i, r := range runes {
+		last := i == len(runes)-1
+
+		switch {
+		case isEncodedRune(r):
+			b.WriteRune(encodedQuote)
+			b.WriteRune(r)
+		case r < 0x20:
+			b.WriteRune(encodedControlStart + r)
+		case r == 0x7F:
+			b.WriteRune(encodedDelete)
+		case r == '\\':
+			b.WriteRune(encodedBackslash)
+		case r == ' ' && last:
+			b.WriteRune(encodedSpace)
+		case r == '.' && last:
+			b.WriteRune(encodedDot)
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
+
+// Decode converts a name stored in dropbox back to the original name.
+func (StandardEncoder) Decode(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+
+		switch {
+		case r == encodedQuote && i+1 < len(runes) && isEncodedRune(runes[i+1]):
+			i++
+			b.WriteRune(runes[i])
+		case r >= encodedControlStart && r < encodedControlStart+0x20:
+			b.WriteRune(r - encodedControlStart)
+		case r == encodedDelete:
+			b.WriteRune(0x7F)
+		case r == encodedBackslash:
+			b.WriteRune('\\')
+		case r == encodedSpace:
+			b.WriteRune(' ')
+		case r == encodedDot:
+			b.WriteRune('.')
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
+
+// SetNameEncoder defines the encoder used to store the names dropbox doesn't accept.
+// It is disabled by default, a nil encoder disables it.
+// When enabled, backslashes are considered as part of the names instead of path separators.
+func (fs *Fs) SetNameEncoder(encoder NameEncoder) {
+	fs.settings.Lock()
+	defer fs.settings.Unlock()
+
+	fs.encoder = encoder
+}
+
+func (fs *Fs) nameEncoder() NameEncoder {
+	fs.settings.RLock()
+	defer fs.settings.RUnlock()
+
+	return fs.encoder
+}
+
+// encodePath encodes all the elements of a path.
+func (fs *Fs) encodePath(name string) string {
+	encoder := fs.nameEncoder()
+	if encoder == nil {
+		return name
+	}
+
+	parts := strings.Split(name, "/")
+
+	for i, part := range parts {
+		if part != "" && part != "." && part != ".." {
+			parts[i] = encoder.Encode(part)
+		}
+	}
+
+	return strings.Join(parts, "/")
+}
+
+// decodePath decodes all the elements of a path.
+func (fs *Fs) decodePath(name string) string {
+	encoder := fs.nameEncoder()
+	if encoder == nil {
+		return name
+	}
+
+	parts := strings.Split(name, "/")
+
+	for i, part := range parts {
+		parts[i] = encoder.Decode(part)
+	}
+
+	return strings.Join(parts, "/")
+}
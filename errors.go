@@ -1,6 +1,9 @@
 package dropbox // nolint: golint
 
-import "errors"
+import (
+	"errors"
+	"strings"
+)
 
 // ErrNotSupported is returned when this operations is not supported by S3.
 var ErrNotSupported = errors.New("dropbox doesn't support this operation")
@@ -10,3 +13,45 @@ var ErrAlreadyOpened = errors.New("already opened")
 
 // ErrInvalidSeek is returned when the seek operation is not doable.
 var ErrInvalidSeek = errors.New("invalid seek offset")
+
+// ErrOutsideRoot is returned when a name refers to a path outside of the root directory.
+var ErrOutsideRoot = errors.New("path is outside of the root directory")
+
+// ErrRootDirectory is returned when an operation would remove or move the root directory.
+var ErrRootDirectory = errors.New("operation not permitted on the root directory")
+
+// ErrInvalidName is returned when a name can't be stored by dropbox.
+var ErrInvalidName = errors.New("invalid name")
+
+// ErrConflict is returned when a file was modified by someone else.
+var ErrConflict = errors.New("file was modified concurrently")
+
+// ErrBatchFailed is returned when a batch operation failed as a whole.
+var ErrBatchFailed = errors.New("batch operation failed")
+
+// ErrEmptyBatch is returned when a batch operation is given no entries.
+var ErrEmptyBatch = errors.New("batch has no entries")
+
+// ErrSpoolTooLarge is returned when a spooled file would exceed the maximum size of the spool.
+var ErrSpoolTooLarge = errors.New("file is too large for the spool")
+
+// isNotFound checks if an API error is due to a missing file.
+// All the endpoint errors share the same summary format, like "path_lookup/not_found/..".
+func isNotFound(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "/not_found/")
+}
+
+// isConflict checks if an API error is due to an existing file.
+func isConflict(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "/conflict/")
+}
+
+// isFolderConflict checks if an API error is due to an existing folder.
+func isFolderConflict(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "/conflict/folder/")
+}
+
+// isPropertyGroupConflict checks if a property group couldn't be added because it already exists.
+func isPropertyGroupConflict(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "property_group_already_exists")
+}
@@ -0,0 +1,1007 @@
+package dropbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dropbox/dropbox-sdk-go-unofficial/dropbox"
+)
+
+// fakeServer is an in-memory implementation of the subset of the dropbox API used by this package.
+// It allows to run the tests without any dropbox account.
+type fakeServer struct {
+	sync.Mutex
+	entries   map[string]*fakeEntry
+	cursors   map[string][]*fakeEntry
+	jobs      map[string]*fakeJob
+	sessions  map[string][]byte
+	templates map[string]string
+	counter   int
+}
+
+type fakeJob struct {
+	checked bool
+	results []interface{}
+}
+
+type fakeEntry struct {
+	id             string
+	pathDisplay    string
+	isDir          bool
+	content        []byte
+	rev            string
+	clientModified time.Time
+	serverModified time.Time
+	properties     map[string][]fakeProperty
+}
+
+type fakeError struct {
+	status  int
+	summary string
+	fields  map[string]interface{} // fields of the innermost tag
+}
+
+func (e *fakeError) Error() string {
+	return e.summary
+}
+
+func newFakeFs(t *testing.T) *Fs {
+	srv := &fakeServer{
+		entries:   make(map[string]*fakeEntry),
+		cursors:   make(map[string][]*fakeEntry),
+		jobs:      make(map[string]*fakeJob),
+		sessions:  make(map[string][]byte),
+		templates: make(map[string]string),
+	}
+
+	server := httptest.NewServer(srv)
+
+	// Some tests leave files opened for writing, their uploads have to be interrupted
+	t.Cleanup(func() {
+		server.CloseClientConnections()
+		server.Close()
+	})
+
+	return newFs(dropbox.Config{
+		Token:  "fake",
+		Client: server.Client(),
+		URLGenerator: func(hostType string, style string, namespace string, route string) string {
+			return fmt.Sprintf("%s/2/%s/%s", server.URL, namespace, route)
+		},
+	})
+}
+
+func (s *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/2/"), "files/")
+
+	var arg []byte
+
+	var body []byte
+
+	var err error
+
+	if header := r.Header.Get("Dropbox-API-Arg"); header != "" {
+		arg = []byte(header)
+
+		// Like the real API, conflicts are reported before the content is received
+		if errConflict := s.checkUpload(route, arg); errConflict != nil {
+			s.writeError(w, errConflict)
+
+			return
+		}
+
+		body, err = ioutil.ReadAll(r.Body)
+	} else {
+		arg, err = ioutil.ReadAll(r.Body)
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	res, content, err := s.handle(route, arg, body, r.Header)
+
+	if err != nil {
+		s.writeError(w, err)
+
+		return
+	}
+
+	if content != nil {
+		result, _ := json.Marshal(res)
+		w.Header().Set("Dropbox-API-Result", string(result))
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		_, _ = w.Write(content)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+func (s *fakeServer) writeError(w http.ResponseWriter, err error) {
+	fErr, ok := err.(*fakeError) // nolint: errorlint
+	if !ok {
+		fErr = &fakeError{status: http.StatusBadRequest, summary: err.Error()}
+	}
+
+	if fErr.status != http.StatusConflict {
+		http.Error(w, fErr.summary, fErr.status)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"error_summary": fErr.summary + "..",
+		"error":         taggedError(fErr),
+	})
+}
+
+// taggedError converts the "a/b/c/" summary of an error to the {".tag": "a", "a": {".tag": "b", "b": {".tag": "c"}}} union.
+func taggedError(err error) interface{} {
+	tags := strings.Split(strings.Trim(err.Error(), "/"), "/")
+
+	var union interface{}
+
+	for i := len(tags) - 1; i >= 0; i-- {
+		tagged := map[string]interface{}{".tag": tags[i]}
+		if union != nil {
+			tagged[tags[i]] = union
+		} else if fErr, ok := err.(*fakeError); ok { // nolint: errorlint
+			for k, v := range fErr.fields {
+				tagged[k] = v
+			}
+		}
+
+		union = tagged
+	}
+
+	return union
+}
+
+func conflict(summary string) error {
+	return &fakeError{status: http.StatusConflict, summary: summary}
+}
+
+// nolint: gocyclo,funlen
+func (s *fakeServer) handle(route string, arg, body []byte, header http.Header) (interface{}, []byte, error) {
+	if strings.HasPrefix(route, "upload_session/") {
+		return s.handleSession(route, arg, body)
+	}
+
+	if strings.HasPrefix(route, "file_properties/") {
+		return s.handleProperties(strings.TrimPrefix(route, "file_properties/"), arg)
+	}
+
+	var req struct {
+		Path       string `json:"path"`
+		FromPath   string `json:"from_path"`
+		ToPath     string `json:"to_path"`
+		Recursive  bool   `json:"recursive"`
+		Limit      int    `json:"limit"`
+		Cursor     string `json:"cursor"`
+		AsyncJobID string `json:"async_job_id"`
+		ParentRev  string `json:"parent_rev"`
+		Entries    []struct {
+			Path     string `json:"path"`
+			FromPath string `json:"from_path"`
+			ToPath   string `json:"to_path"`
+		} `json:"entries"`
+		Query          string `json:"query"`
+		Autorename     bool   `json:"autorename"`
+		StrictConflict bool   `json:"strict_conflict"`
+		ClientModified string `json:"client_modified"`
+		Mode           struct {
+			Tag    string `json:".tag"`
+			Update string `json:"update"`
+		} `json:"mode"`
+		Options struct {
+			Path           string           `json:"path"`
+			MaxResults     int              `json:"max_results"`
+			FileExtensions []string         `json:"file_extensions"`
+			FileCategories []dropbox.Tagged `json:"file_categories"`
+		} `json:"options"`
+	}
+
+	if err := json.Unmarshal(arg, &req); err != nil {
+		return nil, nil, err
+	}
+
+	if len(req.Entries) > batchMaxEntries {
+		return nil, nil, &fakeError{status: http.StatusBadRequest, summary: "entries: too many entries"}
+	}
+
+	switch route {
+	case "get_metadata":
+		if req.Path == "" {
+			return nil, nil, fmt.Errorf("path: The root folder is unsupported")
+		}
+
+		e := s.entries[strings.ToLower(req.Path)]
+		if e == nil {
+			return nil, nil, conflict("path/not_found/")
+		}
+
+		return e.metadata(), nil, nil
+	case "create_folder_v2":
+		if e := s.entries[strings.ToLower(req.Path)]; e != nil {
+			return nil, nil, conflict(fmt.Sprintf("path/conflict/%s/", e.kind()))
+		}
+
+		if err := s.createParents(req.Path); err != nil {
+			return nil, nil, err
+		}
+
+		return map[string]interface{}{"metadata": s.put(&fakeEntry{pathDisplay: req.Path, isDir: true}).metadata()}, nil, nil
+	case "delete_v2":
+		if e := s.entries[strings.ToLower(req.Path)]; e != nil && req.ParentRev != "" && e.rev != req.ParentRev {
+			return nil, nil, conflict("path_write/conflict/file/")
+		}
+
+		e, err := s.remove(req.Path)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return map[string]interface{}{"metadata": e.metadata()}, nil, nil
+	case "move_v2":
+		e, err := s.move(req.FromPath, req.ToPath)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return map[string]interface{}{"metadata": e.metadata()}, nil, nil
+	case "copy_v2":
+		if req.Autorename && s.entries[strings.ToLower(req.ToPath)] != nil {
+			req.ToPath = s.availableName(req.ToPath)
+		}
+
+		e, err := s.copy(req.FromPath, req.ToPath)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return map[string]interface{}{"metadata": e.metadata()}, nil, nil
+	case "delete_batch":
+		results := make([]interface{}, 0, len(req.Entries))
+
+		for _, entry := range req.Entries {
+			if e, err := s.remove(entry.Path); err != nil {
+				results = append(results, map[string]interface{}{".tag": "failure", "failure": taggedError(err)})
+			} else {
+				results = append(results, map[string]interface{}{".tag": "success", "metadata": e.metadata()})
+			}
+		}
+
+		// Deletions are always asynchronous
+		return s.asyncJob(results), nil, nil
+	case "move_batch_v2", "copy_batch_v2":
+		results := make([]interface{}, 0, len(req.Entries))
+
+		for _, entry := range req.Entries {
+			relocate := s.move
+			if route == "copy_batch_v2" {
+				relocate = s.copy
+			}
+
+			if e, err := relocate(entry.FromPath, entry.ToPath); err != nil {
+				failure := map[string]interface{}{".tag": "relocation_error", "relocation_error": taggedError(err)}
+				results = append(results, map[string]interface{}{".tag": "failure", "failure": failure})
+			} else {
+				results = append(results, map[string]interface{}{".tag": "success", "success": e.metadata()})
+			}
+		}
+
+		// Moves are synchronous and copies are asynchronous
+		if route == "move_batch_v2" {
+			return map[string]interface{}{".tag": "complete", "entries": results}, nil, nil
+		}
+
+		return s.asyncJob(results), nil, nil
+	case "delete_batch/check", "move_batch/check_v2", "copy_batch/check_v2":
+		job, ok := s.jobs[req.AsyncJobID]
+		if !ok {
+			return nil, nil, conflict("invalid_async_job_id/")
+		}
+
+		// Jobs are reported as in progress once
+		if !job.checked {
+			job.checked = true
+
+			return map[string]interface{}{".tag": "in_progress"}, nil, nil
+		}
+
+		delete(s.jobs, req.AsyncJobID)
+
+		return map[string]interface{}{".tag": "complete", "entries": job.results}, nil, nil
+	case "list_folder":
+		if req.Path != "" {
+			e := s.entries[strings.ToLower(req.Path)]
+			if e == nil {
+				return nil, nil, conflict("path/not_found/")
+			} else if !e.isDir {
+				return nil, nil, conflict("path/not_folder/")
+			}
+		}
+
+		entries := s.children(req.Path, req.Recursive)
+		if req.Recursive && req.Path != "" {
+			entries = append([]*fakeEntry{s.entries[strings.ToLower(req.Path)]}, entries...)
+		}
+
+		// Like dropbox, the limit is approximate: the smallest one starts with an empty page
+		if req.Limit == 1 && len(entries) > 0 {
+			cursor := s.nextID()
+			s.cursors[cursor] = entries
+
+			return map[string]interface{}{"entries": []interface{}{}, "cursor": cursor, "has_more": true}, nil, nil
+		}
+
+		return s.listResult(entries, req.Limit), nil, nil
+	case "list_folder/continue":
+		entries, ok := s.cursors[req.Cursor]
+		if !ok {
+			return nil, nil, conflict("reset/")
+		}
+
+		delete(s.cursors, req.Cursor)
+
+		return s.listResult(entries, len(entries)), nil, nil
+	case "upload":
+		e, err := s.upload(req.Path, req.Mode.Tag, req.Mode.Update, req.Autorename, req.StrictConflict, body)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if t, errParse := time.Parse(time.RFC3339Nano, req.ClientModified); errParse == nil && !t.IsZero() && t.Year() > 1 {
+			e.clientModified = t
+		}
+
+		return e.metadata(), nil, nil
+	case "download":
+		e := s.entries[strings.ToLower(req.Path)]
+		if e == nil {
+			return nil, nil, conflict("path/not_found/")
+		} else if e.isDir {
+			return nil, nil, conflict("path/not_file/")
+		}
+
+		content := e.content
+
+		if r := header.Get("Range"); r != "" {
+			var start int
+			if _, err := fmt.Sscanf(r, "bytes=%d-", &start); err != nil {
+				return nil, nil, err
+			}
+
+			if start > len(content) {
+				start = len(content)
+			}
+
+			content = content[start:]
+		}
+
+		return e.metadata(), append([]byte{}, content...), nil
+	case "search_v2":
+		return s.search(req.Query, req.Options.Path, req.Options.MaxResults,
+			req.Options.FileExtensions, req.Options.FileCategories), nil, nil
+	case "search/continue_v2":
+		entries, ok := s.cursors[req.Cursor]
+		if !ok {
+			return nil, nil, conflict("internal_error/")
+		}
+
+		delete(s.cursors, req.Cursor)
+
+		return s.searchResult(entries, len(entries)), nil, nil
+	}
+
+	return nil, nil, &fakeError{status: http.StatusNotFound, summary: "unknown route " + route}
+}
+
+func (s *fakeServer) asyncJob(results []interface{}) interface{} {
+	id := s.nextID()
+	s.jobs[id] = &fakeJob{results: results}
+
+	return map[string]interface{}{".tag": "async_job_id", "async_job_id": id}
+}
+
+func (s *fakeServer) remove(p string) (*fakeEntry, error) {
+	e := s.entries[strings.ToLower(p)]
+	if e == nil {
+		return nil, conflict("path_lookup/not_found/")
+	}
+
+	for _, c := range s.children(p, true) {
+		delete(s.entries, strings.ToLower(c.pathDisplay))
+	}
+
+	delete(s.entries, strings.ToLower(p))
+
+	return e, nil
+}
+
+func (s *fakeServer) move(from, to string) (*fakeEntry, error) {
+	e := s.entries[strings.ToLower(from)]
+	if e == nil {
+		return nil, conflict("from_lookup/not_found/")
+	}
+
+	// Only the case of a name can be changed in place
+	if existing := s.entries[strings.ToLower(to)]; existing != nil && (existing != e || from == to) {
+		return nil, conflict(fmt.Sprintf("to/conflict/%s/", existing.kind()))
+	}
+
+	if err := s.createParents(to); err != nil {
+		return nil, err
+	}
+
+	for _, c := range s.children(from, true) {
+		delete(s.entries, strings.ToLower(c.pathDisplay))
+		c.pathDisplay = to + c.pathDisplay[len(from):]
+		s.entries[strings.ToLower(c.pathDisplay)] = c
+	}
+
+	delete(s.entries, strings.ToLower(from))
+	e.pathDisplay = to
+	s.entries[strings.ToLower(e.pathDisplay)] = e
+
+	return e, nil
+}
+
+func (s *fakeServer) copy(from, to string) (*fakeEntry, error) {
+	e := s.entries[strings.ToLower(from)]
+	if e == nil {
+		return nil, conflict("from_lookup/not_found/")
+	}
+
+	if existing := s.entries[strings.ToLower(to)]; existing != nil {
+		return nil, conflict(fmt.Sprintf("to/conflict/%s/", existing.kind()))
+	}
+
+	if err := s.createParents(to); err != nil {
+		return nil, err
+	}
+
+	for _, c := range s.children(from, true) {
+		s.clone(c, to+c.pathDisplay[len(from):])
+	}
+
+	return s.clone(e, to), nil
+}
+
+func (s *fakeServer) clone(e *fakeEntry, p string) *fakeEntry {
+	c := s.put(&fakeEntry{pathDisplay: p, isDir: e.isDir, content: e.content})
+	c.clientModified = e.clientModified
+
+	return c
+}
+
+func (s *fakeServer) nextID() string {
+	s.counter++
+
+	return fmt.Sprintf("%09x", s.counter)
+}
+
+func (s *fakeServer) put(e *fakeEntry) *fakeEntry {
+	if e.id == "" {
+		e.id = "id:" + s.nextID()
+	}
+
+	if !e.isDir {
+		e.rev = s.nextID()
+		e.serverModified = time.Now().UTC()
+		e.clientModified = e.serverModified
+	}
+
+	s.entries[strings.ToLower(e.pathDisplay)] = e
+
+	return e
+}
+
+func (s *fakeServer) createParents(p string) error {
+	var missing []string
+
+	// Nothing is created if a file is in the way
+	for dir := path.Dir(p); dir != "/"; dir = path.Dir(dir) {
+		if e := s.entries[strings.ToLower(dir)]; e == nil {
+			missing = append(missing, dir)
+		} else if !e.isDir {
+			return conflict("path/conflict/file/")
+		}
+	}
+
+	for _, dir := range missing {
+		s.put(&fakeEntry{pathDisplay: dir, isDir: true})
+	}
+
+	return nil
+}
+
+func (s *fakeServer) children(dir string, recursive bool) []*fakeEntry {
+	prefix := strings.ToLower(strings.TrimSuffix(dir, "/")) + "/"
+
+	var list []*fakeEntry
+
+	for key, e := range s.entries {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		if !recursive && strings.Contains(key[len(prefix):], "/") {
+			continue
+		}
+
+		list = append(list, e)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].pathDisplay < list[j].pathDisplay })
+
+	return list
+}
+
+func (s *fakeServer) listResult(entries []*fakeEntry, limit int) interface{} {
+	if limit <= 0 || limit > len(entries) {
+		limit = len(entries)
+	}
+
+	cursor := s.nextID()
+	if limit < len(entries) {
+		s.cursors[cursor] = entries[limit:]
+	}
+
+	list := make([]interface{}, 0, limit)
+	for _, e := range entries[:limit] {
+		list = append(list, e.metadata())
+	}
+
+	return map[string]interface{}{
+		"entries":  list,
+		"cursor":   cursor,
+		"has_more": limit < len(entries),
+	}
+}
+
+// nolint: gocyclo
+func (s *fakeServer) upload(p, mode, rev string, autorename, strict bool, content []byte) (*fakeEntry, error) {
+	existing := s.entries[strings.ToLower(p)]
+
+	if existing != nil {
+		isConflict := false
+
+		switch {
+		case existing.isDir:
+			isConflict = true
+		case mode == "add":
+			isConflict = strict || string(existing.content) != string(content)
+		case mode == "update":
+			isConflict = existing.rev != rev
+		}
+
+		if !isConflict && mode == "add" {
+			return existing, nil
+		}
+
+		if isConflict {
+			if !autorename {
+				return nil, conflict(fmt.Sprintf("path/conflict/%s/", existing.kind()))
+			}
+
+			p = s.availableName(p)
+			existing = nil
+		}
+	} else if mode == "update" {
+		return nil, conflict("path/conflict/file/")
+	}
+
+	if err := s.createParents(p); err != nil {
+		return nil, err
+	}
+
+	e := &fakeEntry{pathDisplay: p, content: content}
+	if existing != nil {
+		e.id = existing.id
+		e.properties = existing.properties
+	}
+
+	return s.put(e), nil
+}
+
+// checkUpload checks if an upload conflicts with an existing file, without its content.
+func (s *fakeServer) checkUpload(route string, arg []byte) error {
+	var req struct {
+		Path           string `json:"path"`
+		Autorename     bool   `json:"autorename"`
+		StrictConflict bool   `json:"strict_conflict"`
+		Mode           struct {
+			Tag    string `json:".tag"`
+			Update string `json:"update"`
+		} `json:"mode"`
+	}
+
+	if route != "upload" || json.Unmarshal(arg, &req) != nil || req.Autorename {
+		return nil
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	existing := s.entries[strings.ToLower(req.Path)]
+
+	switch {
+	case existing == nil && req.Mode.Tag == "update":
+		return conflict("path/conflict/file/")
+	case existing == nil:
+		return nil
+	case existing.isDir,
+		req.Mode.Tag == "add" && req.StrictConflict,
+		req.Mode.Tag == "update" && existing.rev != req.Mode.Update:
+		return conflict(fmt.Sprintf("path/conflict/%s/", existing.kind()))
+	}
+
+	return nil
+}
+
+// handleSession implements the upload sessions, their cursor argument conflicts with the listing one.
+func (s *fakeServer) handleSession(route string, arg, body []byte) (interface{}, []byte, error) {
+	var req struct {
+		Cursor struct {
+			SessionID string `json:"session_id"`
+			Offset    int    `json:"offset"`
+		} `json:"cursor"`
+		Commit struct {
+			Path           string `json:"path"`
+			Autorename     bool   `json:"autorename"`
+			StrictConflict bool   `json:"strict_conflict"`
+			ClientModified string `json:"client_modified"`
+			Mode           struct {
+				Tag    string `json:".tag"`
+				Update string `json:"update"`
+			} `json:"mode"`
+		} `json:"commit"`
+	}
+
+	if err := json.Unmarshal(arg, &req); err != nil {
+		return nil, nil, err
+	}
+
+	if route == "upload_session/start" {
+		s.counter++
+		id := fmt.Sprintf("session%d", s.counter)
+		s.sessions[id] = append([]byte{}, body...)
+
+		return map[string]interface{}{"session_id": id}, nil, nil
+	}
+
+	// The finish errors wrap the session lookup ones
+	lookup := ""
+	if route == "upload_session/finish" {
+		lookup = "lookup_failed/"
+	}
+
+	content, ok := s.sessions[req.Cursor.SessionID]
+	if !ok {
+		return nil, nil, conflict(lookup + "not_found/")
+	} else if req.Cursor.Offset != len(content) {
+		return nil, nil, &fakeError{
+			status:  http.StatusConflict,
+			summary: lookup + "incorrect_offset/",
+			fields:  map[string]interface{}{"correct_offset": len(content)},
+		}
+	}
+
+	content = append(content, body...)
+	s.sessions[req.Cursor.SessionID] = content
+
+	switch route {
+	case "upload_session/append_v2":
+		return nil, nil, nil
+	case "upload_session/finish":
+		delete(s.sessions, req.Cursor.SessionID)
+
+		e, err := s.upload(req.Commit.Path, req.Commit.Mode.Tag, req.Commit.Mode.Update,
+			req.Commit.Autorename, req.Commit.StrictConflict, content)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if t, errParse := time.Parse(time.RFC3339Nano, req.Commit.ClientModified); errParse == nil && t.Year() > 1 {
+			e.clientModified = t
+		}
+
+		return e.metadata(), nil, nil
+	}
+
+	return nil, nil, &fakeError{status: http.StatusNotFound, summary: "unknown route " + route}
+}
+
+// handleProperties implements the templates owned by the user and the properties of the files.
+func (s *fakeServer) handleProperties(route string, arg []byte) (interface{}, []byte, error) {
+	var req struct {
+		TemplateID     string `json:"template_id"`
+		Name           string `json:"name"`
+		Path           string `json:"path"`
+		PropertyGroups []struct {
+			TemplateID string         `json:"template_id"`
+			Fields     []fakeProperty `json:"fields"`
+		} `json:"property_groups"`
+		UpdatePropertyGroups []struct {
+			TemplateID        string         `json:"template_id"`
+			AddOrUpdateFields []fakeProperty `json:"add_or_update_fields"`
+		} `json:"update_property_groups"`
+	}
+
+	if len(arg) > 0 && string(arg) != "null" {
+		if err := json.Unmarshal(arg, &req); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	switch route {
+	case "templates/list_for_user":
+		ids := make([]string, 0, len(s.templates))
+		for id := range s.templates {
+			ids = append(ids, id)
+		}
+
+		sort.Strings(ids)
+
+		return map[string]interface{}{"template_ids": ids}, nil, nil
+	case "templates/get_for_user":
+		name, ok := s.templates[req.TemplateID]
+		if !ok {
+			return nil, nil, conflict("template_not_found/")
+		}
+
+		return map[string]interface{}{"name": name, "description": "", "fields": []interface{}{}}, nil, nil
+	case "templates/add_for_user":
+		s.counter++
+		id := fmt.Sprintf("ptid:%d", s.counter)
+		s.templates[id] = req.Name
+
+		return map[string]interface{}{"template_id": id}, nil, nil
+	case "properties/add", "properties/overwrite":
+		e := s.entries[strings.ToLower(req.Path)]
+		if e == nil {
+			return nil, nil, conflict("path/not_found/")
+		}
+
+		for _, group := range req.PropertyGroups {
+			if _, ok := s.templates[group.TemplateID]; !ok {
+				return nil, nil, conflict("template_not_found/")
+			}
+
+			if _, ok := e.properties[group.TemplateID]; ok && route == "properties/add" {
+				return nil, nil, conflict("property_group_already_exists/")
+			}
+
+			if e.properties == nil {
+				e.properties = make(map[string][]fakeProperty)
+			}
+
+			e.properties[group.TemplateID] = group.Fields
+		}
+
+		return nil, nil, nil
+	case "properties/update":
+		e := s.entries[strings.ToLower(req.Path)]
+		if e == nil {
+			return nil, nil, conflict("path/not_found/")
+		}
+
+		for _, group := range req.UpdatePropertyGroups {
+			fields, ok := e.properties[group.TemplateID]
+			if !ok {
+				return nil, nil, conflict("property_group_lookup/property_group_not_found/")
+			}
+
+			for _, update := range group.AddOrUpdateFields {
+				fields = setFakeProperty(fields, update)
+			}
+
+			e.properties[group.TemplateID] = fields
+		}
+
+		return nil, nil, nil
+	}
+
+	return nil, nil, &fakeError{status: http.StatusNotFound, summary: "unknown route " + route}
+}
+
+// fakeProperty is a field of a property group.
+type fakeProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// setFakeProperty adds or replaces a field of a property group.
+func setFakeProperty(fields []fakeProperty, field fakeProperty) []fakeProperty {
+	updated := make([]fakeProperty, 0, len(fields)+1)
+
+	for _, f := range fields {
+		if f.Name != field.Name {
+			updated = append(updated, f)
+		}
+	}
+
+	return append(updated, field)
+}
+
+func (s *fakeServer) availableName(p string) string {
+	ext := path.Ext(p)
+	base := strings.TrimSuffix(p, ext)
+
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, i, ext)
+		if s.entries[strings.ToLower(candidate)] == nil {
+			return candidate
+		}
+	}
+}
+
+// nolint: gochecknoglobals
+var fakeCategories = map[string][]string{
+	"image":        {"jpg", "jpeg", "png", "gif", "bmp", "tiff", "heic"},
+	"document":     {"doc", "docx", "odt", "rtf", "txt", "md"},
+	"pdf":          {"pdf"},
+	"spreadsheet":  {"xls", "xlsx", "ods", "csv"},
+	"presentation": {"ppt", "pptx", "odp", "key"},
+	"audio":        {"mp3", "wav", "flac", "ogg", "m4a"},
+	"video":        {"mp4", "mov", "avi", "mkv"},
+	"paper":        {"paper"},
+}
+
+func (e *fakeEntry) category() string {
+	if e.isDir {
+		return "folder"
+	}
+
+	ext := strings.TrimPrefix(strings.ToLower(path.Ext(e.pathDisplay)), ".")
+
+	for category, extensions := range fakeCategories {
+		for _, x := range extensions {
+			if x == ext {
+				return category
+			}
+		}
+	}
+
+	return "others"
+}
+
+func (e *fakeEntry) matches(query string, extensions []string, categories []dropbox.Tagged) bool {
+	name := strings.ToLower(path.Base(e.pathDisplay))
+
+	for _, token := range strings.Fields(strings.ToLower(query)) {
+		if !strings.Contains(name, token) {
+			return false
+		}
+	}
+
+	if len(extensions) > 0 {
+		found := false
+		ext := strings.TrimPrefix(path.Ext(name), ".")
+
+		for _, x := range extensions {
+			found = found || strings.EqualFold(x, ext)
+		}
+
+		if !found || e.isDir {
+			return false
+		}
+	}
+
+	if len(categories) > 0 {
+		found := false
+
+		for _, c := range categories {
+			found = found || c.Tag == e.category()
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (s *fakeServer) search(query, dir string, maxResults int, extensions []string, categories []dropbox.Tagged) interface{} {
+	var entries []*fakeEntry
+
+	for _, e := range s.children(dir, true) {
+		if e.matches(query, extensions, categories) {
+			entries = append(entries, e)
+		}
+	}
+
+	return s.searchResult(entries, maxResults)
+}
+
+func (s *fakeServer) searchResult(entries []*fakeEntry, limit int) interface{} {
+	if limit <= 0 || limit > len(entries) {
+		limit = len(entries)
+	}
+
+	cursor := ""
+	if limit < len(entries) {
+		cursor = s.nextID()
+		s.cursors[cursor] = entries[limit:]
+	}
+
+	matches := make([]interface{}, 0, limit)
+	for _, e := range entries[:limit] {
+		matches = append(matches, map[string]interface{}{
+			"metadata":   map[string]interface{}{".tag": "metadata", "metadata": e.metadata()},
+			"match_type": map[string]interface{}{".tag": "filename"},
+		})
+	}
+
+	return map[string]interface{}{
+		"matches":  matches,
+		"cursor":   cursor,
+		"has_more": cursor != "",
+	}
+}
+
+func (e *fakeEntry) kind() string {
+	if e.isDir {
+		return "folder"
+	}
+
+	return "file"
+}
+
+func (e *fakeEntry) metadata() map[string]interface{} {
+	meta := map[string]interface{}{
+		".tag":         e.kind(),
+		"id":           e.id,
+		"name":         path.Base(e.pathDisplay),
+		"path_display": e.pathDisplay,
+		"path_lower":   strings.ToLower(e.pathDisplay),
+	}
+
+	if !e.isDir {
+		meta["size"] = len(e.content)
+		meta["rev"] = e.rev
+		meta["client_modified"] = e.clientModified.Format(time.RFC3339Nano)
+		meta["server_modified"] = e.serverModified.Format(time.RFC3339Nano)
+	}
+
+	// The real API only returns the requested groups
+	if len(e.properties) > 0 {
+		ids := make([]string, 0, len(e.properties))
+		for id := range e.properties {
+			ids = append(ids, id)
+		}
+
+		sort.Strings(ids)
+
+		groups := make([]interface{}, 0, len(ids))
+		for _, id := range ids {
+			groups = append(groups, map[string]interface{}{"template_id": id, "fields": e.properties[id]})
+		}
+
+		meta["property_groups"] = groups
+	}
+
+	return meta
+}
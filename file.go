@@ -1,31 +1,46 @@
 package dropbox // nolint: golint
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
-	"github.com/dropbox/dropbox-sdk-go-unofficial/dropbox"
 	"github.com/dropbox/dropbox-sdk-go-unofficial/dropbox/files"
 	"github.com/spf13/afero"
 )
 
 // File represents a file structure.
+// It's safe for concurrent use, its operations are serialized.
 type File struct {
-	fs                  *Fs
-	name                string
-	streamWrite         io.WriteCloser
-	streamRead          io.ReadCloser
-	streamWriteCloseErr chan error
-	streamWriteErr      error
-	dirList             chan os.FileInfo
-	dirListCursor       string
-	dirListDone         bool
-	streamReadOffset    int64
-	cachedInfo          os.FileInfo
+	mu               sync.Mutex
+	fs               *Fs
+	name             string
+	streamWrite      io.WriteCloser
+	streamRead       io.ReadCloser
+	streamWriteDone  chan struct{}
+	streamWriteErr   error
+	streamWriteMeta  *files.FileMetadata
+	dirList          chan os.FileInfo
+	dirListCursor    string
+	dirListDone      bool
+	streamReadOffset int64
+	cachedInfo       os.FileInfo
+	writeOptions     *WriteOptions
+	state            fileState
+	uploadOnClose    bool
+	buffer           *writeBuffer
+	bufferOffset     int64
+	progressFunc     ProgressFunc
+	transfer         *transferProgress
+	readLimiter      *rateLimiter
+	writeLimiter     *rateLimiter
 }
 
 const (
@@ -33,17 +48,68 @@ const (
 	simulatedFileMode  = 0777
 )
 
+// fileState is the state of a File, it defines the operations it supports.
+type fileState int
+
+const (
+	// fileStateReading is a file opened for reading
+	fileStateReading fileState = iota
+	// fileStateWriting is a file opened for writing, the upload starts with the first write
+	fileStateWriting
+	// fileStateDirectory is a directory opened for listing
+	fileStateDirectory
+	// fileStateClosed is a closed file, only Name and Stat can still be used
+	fileStateClosed
+)
+
 func newFile(fs *Fs, name string) *File {
 	return &File{
-		fs:                  fs,
-		name:                name,
-		streamWriteCloseErr: make(chan error),
+		fs:   fs,
+		name: name,
+	}
+}
+
+// checkState returns the error of an operation that requires the file to be in the expected state.
+func (f *File) checkState(op string, expected fileState) error {
+	switch {
+	case f.state == expected:
+		return nil
+	case f.state == fileStateClosed:
+		return afero.ErrFileClosed
+	case f.state == fileStateDirectory:
+		return &os.PathError{Op: op, Path: f.relativeName(), Err: syscall.EISDIR}
+	case expected == fileStateDirectory:
+		return &os.PathError{Op: op, Path: f.relativeName(), Err: syscall.ENOTDIR}
+	default:
+		return &os.PathError{Op: op, Path: f.relativeName(), Err: os.ErrInvalid}
 	}
 }
 
 // Close closes the File, rendering it unusable for I/O.
 // It returns an error, if any.
+// Name and Stat can still be used, they describe the uploaded file if it was opened for writing.
 func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	state := f.state
+	if state == fileStateClosed {
+		return afero.ErrFileClosed
+	}
+
+	f.state = fileStateClosed
+
+	if f.buffer != nil {
+		return f.closeBuffer()
+	}
+
+	// Nothing was written, we only upload an empty file if it was created or truncated
+	if state == fileStateWriting && f.streamWrite == nil && f.uploadOnClose {
+		if err := f.openWriteStream(); err != nil {
+			return err
+		}
+	}
+
 	// Closing a reading stream
 	if f.streamRead != nil {
 		// We try to close the Reader
@@ -58,7 +124,6 @@ func (f *File) Close() error {
 	if f.streamWrite != nil {
 		defer func() {
 			f.streamWrite = nil
-			f.streamWriteCloseErr = nil
 		}()
 
 		// We try to close the Writer
@@ -66,10 +131,13 @@ func (f *File) Close() error {
 			return fmt.Errorf("problem writing file: %w", err)
 		}
 		// And more importantly, we wait for the actual writing performed in go-routine to finish.
-		err := <-f.streamWriteCloseErr
-		close(f.streamWriteCloseErr)
+		<-f.streamWriteDone
 
-		return err
+		if f.streamWriteMeta != nil {
+			f.uploaded(f.streamWriteMeta)
+		}
+
+		return f.streamWriteErr
 	}
 
 	// Or maybe we don't have anything to close
@@ -80,7 +148,33 @@ func (f *File) Close() error {
 // It returns the number of bytes read and an error, if any.
 // EOF is signaled by a zero count with err set to io.EOF.
 func (f *File) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.read(p)
+}
+
+func (f *File) read(p []byte) (int, error) {
+	if err := f.checkState("read", fileStateReading); err != nil {
+		return 0, err
+	}
+
+	// The stream couldn't be opened again after a seek
+	if f.streamRead == nil {
+		return 0, &os.PathError{Op: "read", Path: f.relativeName(), Err: os.ErrInvalid}
+	}
+
+	limiters := f.fs.rateLimiters(TransferDownload, f.readLimiter)
+	if len(limiters) > 0 && len(p) > throttleQuantum {
+		p = p[:throttleQuantum]
+	}
+
 	n, err := f.streamRead.Read(p)
+	waitRate(limiters, n)
+
+	if n > 0 {
+		f.reportProgress(TransferDownload, int64(n), false)
+	}
 
 	if err != nil {
 		if errors.Is(err, io.EOF) {
@@ -100,11 +194,24 @@ func (f *File) Read(p []byte) (int, error) {
 // ReadAt always returns a non-nil error when n < len(b).
 // At end of file, that error is io.EOF.
 func (f *File) ReadAt(p []byte, off int64) (n int, err error) {
-	if _, err := f.Seek(off, io.SeekCurrent); err != nil {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.checkState("read", fileStateReading); err != nil {
+		return 0, err
+	}
+
+	if _, err := f.seek(off, io.SeekStart); err != nil {
 		return 0, err
 	}
 
-	return f.Read(p)
+	for n < len(p) && err == nil {
+		var read int
+		read, err = f.read(p[n:])
+		n += read
+	}
+
+	return n, err
 }
 
 // Seek sets the offset for the next Read or Write on file to offset, interpreted
@@ -113,61 +220,163 @@ func (f *File) ReadAt(p []byte, off int64) (n int, err error) {
 // It returns the new offset and an error, if any.
 // The behavior of Seek on a file opened with O_APPEND is not specified.
 func (f *File) Seek(offset int64, whence int) (int64, error) {
-	// Write seek is not supported
-	if f.streamWrite != nil {
-		return 0, ErrNotSupported
-	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
 
-	// Read seek has its own implementation
-	if f.streamRead != nil {
+	return f.seek(offset, whence)
+}
+
+func (f *File) seek(offset int64, whence int) (int64, error) {
+	switch {
+	case f.state == fileStateWriting && f.buffer != nil:
+		return f.seekBuffer(offset, whence)
+	case f.state == fileStateWriting:
+		// Write seek is not supported
+		return 0, ErrNotSupported
+	case f.state == fileStateReading:
+		// Read seek has its own implementation
 		return f.seekRead(offset, whence)
+	default:
+		return 0, f.checkState("seek", fileStateReading)
 	}
-
-	// Not having a stream
-	return 0, afero.ErrFileClosed
 }
 
 // Write writes len(b) bytes to the File.
 // It returns the number of bytes written and an error, if any.
 // Write returns a non-nil error when n != len(b).
+// The upload only starts with the first write, and Write returns its error as soon as it fails.
 func (f *File) Write(p []byte) (n int, err error) {
-	return f.streamWrite.Write(p)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.write(p)
+}
+
+func (f *File) write(p []byte) (n int, err error) {
+	if err := f.checkState("write", fileStateWriting); err != nil {
+		return 0, err
+	}
+
+	if f.buffer != nil {
+		n, err = f.buffer.WriteAt(p, f.bufferOffset)
+		f.bufferOffset += int64(n)
+
+		return n, err
+	}
+
+	if f.streamWrite == nil {
+		if err := f.openWriteStream(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err = f.writeStream(p)
+
+	// The pipe is closed when the upload fails, we return the upload error instead
+	if err != nil {
+		<-f.streamWriteDone
+
+		if f.streamWriteErr != nil {
+			err = f.streamWriteErr
+		}
+	}
+
+	return n, err
+}
+
+// writeStream writes to the upload pipe, in slices small enough to share the rate limits with the other transfers.
+func (f *File) writeStream(p []byte) (n int, err error) {
+	limiters := f.fs.rateLimiters(TransferUpload, f.writeLimiter)
+
+	for len(p) > 0 {
+		chunk := p
+		if len(limiters) > 0 && len(chunk) > throttleQuantum {
+			chunk = chunk[:throttleQuantum]
+		}
+
+		waitRate(limiters, len(chunk))
+
+		written, errWrite := f.streamWrite.Write(chunk)
+		n += written
+
+		if written > 0 {
+			f.reportProgress(TransferUpload, int64(written), false)
+		}
+
+		if errWrite != nil {
+			return n, errWrite // nolint: wrapcheck
+		}
+
+		p = p[written:]
+	}
+
+	return n, nil
 }
 
 // WriteAt writes len(p) bytes to the file starting at byte offset off.
 // It returns the number of bytes written and an error, if any.
 // WriteAt returns a non-nil error when n != len(p).
 func (f *File) WriteAt(p []byte, off int64) (n int, err error) {
-	if _, err := f.Seek(off, io.SeekCurrent); err != nil {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.checkState("write", fileStateWriting); err != nil {
+		return 0, err
+	}
+
+	if off < 0 {
+		return 0, &os.PathError{Op: "writeat", Path: f.relativeName(), Err: os.ErrInvalid}
+	}
+
+	if f.buffer != nil {
+		return f.buffer.WriteAt(p, off)
+	}
+
+	if _, err := f.seek(off, io.SeekCurrent); err != nil {
 		return 0, err
 	}
 
-	return f.Write(p)
+	return f.write(p)
 }
 
-// Name returns the file name.
+// Name returns the file name, relative to the root directory of the Fs.
 func (f *File) Name() string {
-	return f.name
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.relativeName()
 }
 
-func newFileInfo(meta files.IsMetadata) os.FileInfo {
-	return &FileInfo{meta: meta}
+func (f *File) relativeName() string {
+	return f.fs.relativePath(f.name)
+}
+
+func (fs *Fs) newFileInfo(meta files.IsMetadata) os.FileInfo {
+	return &FileInfo{meta: meta, encoder: fs.nameEncoder(), posix: fs.posixMetadata(meta)}
 }
 
 // FileInfo is dropbox file description.
 type FileInfo struct {
-	meta files.IsMetadata
+	meta    files.IsMetadata
+	encoder NameEncoder
+	posix   *PosixMetadata
 }
 
-// Name returns the file name.
+// Name returns the file name, decoded if a NameEncoder is set.
 func (f FileInfo) Name() string {
+	name := ""
+
 	if file, ok := f.meta.(*files.FileMetadata); ok {
-		return file.Name
+		name = file.Name
 	} else if folder, ok := f.meta.(*files.FolderMetadata); ok {
-		return folder.Name
-	} else {
-		return ""
+		name = folder.Name
 	}
+
+	if f.encoder != nil {
+		name = f.encoder.Decode(name)
+	}
+
+	return name
 }
 
 // Size returns the file size.
@@ -179,13 +388,21 @@ func (f FileInfo) Size() int64 {
 	return 0
 }
 
-// Mode return the file mode.
+// Mode return the file mode, the stored one if the POSIX metadata emulation is enabled.
 func (f FileInfo) Mode() os.FileMode {
+	if f.posix != nil && f.posix.HasMode() {
+		return f.posix.Mode
+	}
+
 	return simulatedFileMode
 }
 
-// ModTime returns the modification time.
+// ModTime returns the modification time, the stored one if the POSIX metadata emulation is enabled.
 func (f FileInfo) ModTime() time.Time {
+	if f.posix != nil && f.posix.present&posixHasMtime != 0 {
+		return f.posix.Mtime
+	}
+
 	if file, ok := f.meta.(*files.FileMetadata); ok {
 		return file.ClientModified
 	}
@@ -200,8 +417,43 @@ func (f FileInfo) IsDir() bool {
 	return ok
 }
 
-// Sys returns the underlying structure.
+// Rev returns the revision of the file, it can be used with WriteModeUpdate.
+func (f FileInfo) Rev() string {
+	if file, ok := f.meta.(*files.FileMetadata); ok {
+		return file.Rev
+	}
+
+	return ""
+}
+
+// PathDisplay returns the path of the file in dropbox, with the case used to create it.
+func (f FileInfo) PathDisplay() string {
+	if file, ok := f.meta.(*files.FileMetadata); ok {
+		return file.PathDisplay
+	} else if folder, ok := f.meta.(*files.FolderMetadata); ok {
+		return folder.PathDisplay
+	}
+
+	return ""
+}
+
+// PathLower returns the lowercased path of the file in dropbox, it should be used for comparisons.
+func (f FileInfo) PathLower() string {
+	if file, ok := f.meta.(*files.FileMetadata); ok {
+		return file.PathLower
+	} else if folder, ok := f.meta.(*files.FolderMetadata); ok {
+		return folder.PathLower
+	}
+
+	return ""
+}
+
+// Sys returns the underlying structure, or a *PosixMetadata if the POSIX metadata emulation is enabled.
 func (f FileInfo) Sys() interface{} {
+	if f.posix != nil {
+		return f.posix
+	}
+
 	return f.meta
 }
 
@@ -214,10 +466,15 @@ func (f *File) _readDir() error {
 		// We're using a channel as a queue
 		f.dirList = make(chan os.FileInfo, dirListingMaxLimit)
 
-		req := &files.ListFolderArg{Path: f.name}
+		req := &files.ListFolderArg{Path: f.name, IncludePropertyGroups: f.fs.propertyGroupsFilter()}
+
+		// The API expects an empty path for the root
+		if req.Path == "/" {
+			req.Path = ""
+		}
 
-		if f.fs.dirListLimit != 0 {
-			req.Limit = uint32(f.fs.dirListLimit)
+		if limit := f.fs.listLimit(); limit != 0 {
+			req.Limit = uint32(limit)
 		}
 
 		// We might want to use the limit here...
@@ -234,7 +491,7 @@ func (f *File) _readDir() error {
 	f.dirListDone = !res.HasMore
 
 	for _, m := range res.Entries {
-		f.dirList <- newFileInfo(m)
+		f.dirList <- f.fs.newFileInfo(m)
 	}
 
 	return nil
@@ -243,10 +500,19 @@ func (f *File) _readDir() error {
 // Readdir lists all the files of a directory.
 // Unfortunately the dropbox API doesn't allow to limit the number of returned files per call.
 // so what we're doing here is to using a channel a temporary buffer.
+// Like os.File.Readdir, a count <= 0 returns all the remaining files.
 func (f *File) Readdir(count int) ([]os.FileInfo, error) {
-	list := make([]os.FileInfo, 0, count)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.checkState("readdir", fileStateDirectory); err != nil {
+		return nil, err
+	}
 
-	for len(list) < count && !f.dirListDone {
+	all := count <= 0
+	list := make([]os.FileInfo, 0)
+
+	for (all || len(list) < count) && (len(f.dirList) > 0 || !f.dirListDone) {
 		// If we don't have any available, we should request some
 		if len(f.dirList) == 0 {
 			if err := f._readDir(); err != nil {
@@ -254,7 +520,7 @@ func (f *File) Readdir(count int) ([]os.FileInfo, error) {
 			}
 		}
 
-		for len(list) < count && len(f.dirList) > 0 {
+		for (all || len(list) < count) && len(f.dirList) > 0 {
 			list = append(list, <-f.dirList)
 		}
 	}
@@ -281,6 +547,13 @@ func (f *File) Readdirnames(n int) ([]string, error) {
 
 // Stat fetches the file stat with a cache.
 func (f *File) Stat() (os.FileInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.stat()
+}
+
+func (f *File) stat() (os.FileInfo, error) {
 	var err error
 
 	if f.cachedInfo == nil {
@@ -292,13 +565,108 @@ func (f *File) Stat() (os.FileInfo, error) {
 
 // Sync doesn't do anything.
 func (f *File) Sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.state == fileStateClosed {
+		return afero.ErrFileClosed
+	}
+
 	return nil
 }
 
-// Truncate should truncate a file to a specific size but isn't
-// supported by dropbox.
+// Truncate changes the size of a file opened for writing.
+// Files opened with WriteOptions.Seekable are truncated locally. Other files opened for writing
+// can only be truncated to 0 before anything is written, they are then emptied when they are closed.
+// Like os.File, files opened for reading can't be truncated, Fs.Truncate can truncate them.
 func (f *File) Truncate(size int64) error {
-	return ErrNotSupported
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.checkState("truncate", fileStateWriting); err != nil {
+		if f.state == fileStateReading {
+			return &os.PathError{Op: "truncate", Path: f.relativeName(), Err: os.ErrInvalid}
+		}
+
+		return err
+	}
+
+	switch {
+	case size < 0:
+		return &os.PathError{Op: "truncate", Path: f.relativeName(), Err: os.ErrInvalid}
+	case f.buffer != nil:
+		return f.buffer.Truncate(size)
+	case size == 0 && f.streamWrite == nil:
+		f.uploadOnClose = true
+
+		return nil
+	default:
+		return ErrNotSupported
+	}
+}
+
+// SetModTime defines the modification time of a file opened for writing, see WriteOptions.ModTime.
+// The upload must not have started: it starts with the first write, or when the file is closed
+// if it was opened with WriteOptions.Seekable.
+func (f *File) SetModTime(mtime time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.checkState("chtimes", fileStateWriting); err != nil {
+		return err
+	}
+
+	if f.streamWrite != nil {
+		return ErrAlreadyOpened
+	}
+
+	opts := WriteOptions{}
+	if f.writeOptions != nil {
+		opts = *f.writeOptions
+	}
+
+	opts.ModTime = mtime
+	f.writeOptions = &opts
+
+	return nil
+}
+
+// SetProgressFunc defines the function receiving the progress of the transfers of this file,
+// instead of the one of the Fs.
+func (f *File) SetProgressFunc(fn ProgressFunc) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.progressFunc = fn
+}
+
+// SetRateLimits limits the read and write rates of this file, in bytes per second, on top of the limits of the Fs.
+// 0 means no limit, this is the default.
+func (f *File) SetRateLimits(read, write int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.readLimiter = newRateLimiter(read)
+	f.writeLimiter = newRateLimiter(write)
+}
+
+// reportProgress adds n transferred bytes to the current transfer of the file.
+func (f *File) reportProgress(direction TransferDirection, n int64, chunkDone bool) {
+	if f.transfer == nil {
+		total := int64(-1)
+		if f.cachedInfo != nil {
+			total = f.cachedInfo.Size()
+		}
+
+		f.transfer = newTransferProgress(f.relativeName(), direction, total)
+	}
+
+	fn := f.progressFunc
+	if fn == nil {
+		fn = f.fs.progressFunc()
+	}
+
+	f.transfer.report(fn, n, chunkDone)
 }
 
 // WriteString writes a string.
@@ -306,6 +674,69 @@ func (f *File) WriteString(s string) (ret int, err error) {
 	return f.Write([]byte(s))
 }
 
+// prepareWrite checks the file can be opened for writing with the given flags.
+func (f *File) prepareWrite(name string, flag int) error {
+	f.state = fileStateWriting
+
+	// Creating and truncating doesn't depend on the file existence
+	if flag&os.O_CREATE != 0 && flag&os.O_TRUNC != 0 && flag&os.O_EXCL == 0 {
+		f.uploadOnClose = true
+
+		return nil
+	}
+
+	info, err := f.fs.stat(f.name)
+
+	switch {
+	case err == nil && info.IsDir():
+		return &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	case err == nil && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	case err == nil:
+		f.uploadOnClose = flag&os.O_TRUNC != 0
+	case errors.Is(err, os.ErrNotExist) && flag&os.O_CREATE == 0:
+		return &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	case errors.Is(err, os.ErrNotExist):
+		f.uploadOnClose = true
+	default:
+		return err
+	}
+
+	return nil
+}
+
+// prepareCreate creates an empty file before it's opened for reading, unless it already exists.
+func (f *File) prepareCreate(name string, flag int) error {
+	info, err := f.fs.stat(f.name)
+
+	switch {
+	case err == nil && info.IsDir():
+		return &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	case err == nil && flag&os.O_EXCL != 0:
+		return &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	case err == nil:
+		return nil
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+
+	// Adding doesn't replace a file created in the meantime
+	commit := (&WriteOptions{Mode: WriteModeAdd}).commitInfo(f.name)
+
+	if _, err = f.fs.files.Upload(commit, bytes.NewReader(nil)); err != nil {
+		switch {
+		case isConflict(err) && flag&os.O_EXCL != 0:
+			return &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+		case isConflict(err):
+			return nil
+		default:
+			return fmt.Errorf("couldn't create file: %w", err)
+		}
+	}
+
+	return nil
+}
+
 func (f *File) openWriteStream() error {
 	if f.streamWrite != nil {
 		return ErrAlreadyOpened
@@ -315,31 +746,109 @@ func (f *File) openWriteStream() error {
 
 	reader, writer := io.Pipe()
 
-	f.streamWriteCloseErr = make(chan error)
+	f.streamWriteDone = make(chan struct{})
+	f.streamWriteErr = nil
+	f.streamWriteMeta = nil
 	f.streamWrite = writer
+	f.transfer = newTransferProgress(f.relativeName(), TransferUpload, -1)
 
+	commit := f.writeOptions.commitInfo(f.name)
+
+	// The goroutine only shares its result, it's applied to the file when it's closed
 	go func() {
-		req := &files.CommitInfo{
-			Path: f.name,
-			// Dropbox API has a BUG. TODO: Report it
-			//ClientModified: time.Now().UTC(),
-			Mode:       &files.WriteMode{Tagged: dropbox.Tagged{Tag: "overwrite"}},
-			Autorename: false,
-		}
-		meta, err := f.fs.files.Upload(req, reader)
+		meta, err := f.fs.files.Upload(commit, reader)
 
 		if err != nil {
-			f.streamWriteErr = err
-			_ = f.streamWrite.Close()
+			err = f.uploadError(err)
 		}
 
-		f.cachedInfo = newFileInfo(meta)
-		f.streamWriteCloseErr <- err
+		// The pending and next writes fail with the upload error
+		f.streamWriteMeta = meta
+		f.streamWriteErr = err
+		_ = reader.CloseWithError(err)
+
+		close(f.streamWriteDone)
 	}()
 
 	return nil
 }
 
+// uploaded updates the file with the metadata of its upload.
+func (f *File) uploaded(meta *files.FileMetadata) {
+	f.cachedInfo = f.fs.newFileInfo(meta)
+
+	// The file might have been renamed
+	if !strings.EqualFold(meta.PathDisplay, f.name) && meta.PathDisplay != "" {
+		f.name = meta.PathDisplay
+	}
+}
+
+// closeBuffer uploads the buffered content through an upload session.
+func (f *File) closeBuffer() error {
+	buffer := f.buffer
+	f.buffer = nil
+
+	var err error
+
+	if buffer.written || f.uploadOnClose {
+		err = f.uploadBuffer(buffer)
+	}
+
+	if errClose := buffer.Close(); err == nil {
+		err = errClose
+	}
+
+	return err
+}
+
+func (f *File) uploadBuffer(buffer *writeBuffer) error {
+	f.cachedInfo = nil
+
+	f.transfer = newTransferProgress(f.relativeName(), TransferUpload, buffer.Size())
+	onChunk := func(n int64) { f.reportProgress(TransferUpload, n, true) }
+
+	content := throttle(buffer.Reader(), f.fs.rateLimiters(TransferUpload, f.writeLimiter))
+
+	meta, err := f.fs.uploadSession(f.writeOptions.commitInfo(f.name), content, onChunk)
+	if err != nil {
+		return f.uploadError(err)
+	}
+
+	f.uploaded(meta)
+
+	return nil
+}
+
+func (f *File) seekBuffer(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.bufferOffset
+	case io.SeekEnd:
+		offset += f.buffer.Size()
+	}
+
+	if offset < 0 {
+		return 0, ErrInvalidSeek
+	}
+
+	f.bufferOffset = offset
+
+	return offset, nil
+}
+
+// uploadError converts the conflicts of an upload to errors that can be checked.
+func (f *File) uploadError(err error) error {
+	if !isConflict(err) {
+		return fmt.Errorf("couldn't upload file: %w", err)
+	}
+
+	if f.writeOptions != nil && f.writeOptions.Mode == WriteModeUpdate {
+		return &os.PathError{Op: "write", Path: f.relativeName(), Err: ErrConflict}
+	}
+
+	return &os.PathError{Op: "write", Path: f.relativeName(), Err: os.ErrExist}
+}
+
 func (f *File) openReadStream(startAt int64) error {
 	var err error
 
@@ -375,11 +884,13 @@ func (f *File) seekRead(offset int64, whence int) (int64, error) {
 		startByte = f.cachedInfo.Size() - offset
 	}
 
-	if err := f.streamRead.Close(); err != nil {
-		return 0, fmt.Errorf("couldn't close previous stream: %w", err)
-	}
+	if f.streamRead != nil {
+		if err := f.streamRead.Close(); err != nil {
+			return 0, fmt.Errorf("couldn't close previous stream: %w", err)
+		}
 
-	f.streamRead = nil
+		f.streamRead = nil
+	}
 
 	if startByte < 0 {
 		return startByte, ErrInvalidSeek
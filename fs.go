@@ -7,64 +7,95 @@ import (
 	"os"
 	"path"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/dropbox/dropbox-sdk-go-unofficial/dropbox"
+	"github.com/dropbox/dropbox-sdk-go-unofficial/dropbox/file_properties"
 	"github.com/dropbox/dropbox-sdk-go-unofficial/dropbox/files"
 	"github.com/spf13/afero"
 )
 
 // Fs is the dropbox filesystem.
+// It's safe for concurrent use, including its settings.
 type Fs struct {
 	conf         dropbox.Config
+	api          dropbox.Context
 	files        files.Client
+	properties   file_properties.Client
+	settings     sync.RWMutex // protects the fields below
+	progress     ProgressFunc
+	readLimiter  *rateLimiter
+	writeLimiter *rateLimiter
+	uploadStates UploadStateStore
 	rootPath     string
 	dirListLimit int
+	renameMode   RenameMode
+	encoder      NameEncoder
+	spool        *SpoolOptions
+	// posixTemplate is the properties template storing the POSIX metadata, if its emulation is enabled
+	posixTemplate string
+
+	uploadChunkSize   int
+	writeBufferMemory int64
+	batchSize         int
 }
 
+// RenameMode defines how Rename behaves when the destination already exists.
+type RenameMode int
+
+const (
+	// RenameReplace replaces the destination, like os.Rename does. This is the default.
+	RenameReplace RenameMode = iota
+	// RenameStrict fails when the destination exists.
+	RenameStrict
+)
+
 // NewFs creates new dropbox FS instance.
 func NewFs(token string) *Fs {
-	fs := &Fs{}
-	fs.conf = dropbox.Config{
+	return newFs(dropbox.Config{
 		Token:    token,
 		LogLevel: dropbox.LogInfo,
+	})
+}
+
+func newFs(conf dropbox.Config) *Fs {
+	fs := &Fs{
+		conf:              conf,
+		uploadChunkSize:   uploadSessionChunkSize,
+		writeBufferMemory: writeBufferMemoryLimit,
+		batchSize:         batchMaxEntries,
 	}
 
 	fs.files = files.New(fs.conf)
+	fs.properties = file_properties.New(fs.conf)
+	fs.api = dropbox.NewContext(fs.conf)
 
 	return fs
 }
 
 // Create creates a file.
-// This implementation respects the afero specs but is super slow because it will always open the file two times.
+// Like OpenFile with O_CREATE and O_TRUNC, the file is only uploaded once, when it's closed:
+// it's empty if nothing was written.
 func (fs *Fs) Create(name string) (afero.File, error) {
-	f, err := fs.OpenFile(name, os.O_WRONLY, 0777)
-	if err != nil {
-		return nil, err
-	}
-
-	/*
-		if _, errWrite := f.WriteString(""); errWrite != nil {
-			return nil, fmt.Errorf("couldn't ")
-		}
-	*/
-
-	// We close it to write the file
-	if errClose := f.Close(); errClose != nil {
-		return nil, fmt.Errorf("issue while closing empty file: %w", errClose)
-	}
-
-	// And re-open it to allow to write on it
-	return f, f.(*File).openWriteStream()
+	return fs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0777)
 }
 
 // Mkdir creates a directory.
 func (fs *Fs) Mkdir(name string, _ os.FileMode) error {
-	p := path.Join(fs.rootPath, name)
+	p, err := fs.realPath("mkdir", name)
+	if err != nil {
+		return err
+	}
 
-	_, err := fs.files.CreateFolderV2(&files.CreateFolderArg{Path: p})
+	_, err = fs.files.CreateFolderV2(&files.CreateFolderArg{Path: p})
 
 	if err != nil {
+		if isConflict(err) {
+			return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+		}
+
 		return fmt.Errorf("couldn't create dir: %w", err)
 	}
 
@@ -72,27 +103,32 @@ func (fs *Fs) Mkdir(name string, _ os.FileMode) error {
 }
 
 // MkdirAll creates a directory and all parent directories if necessary.
-func (fs *Fs) MkdirAll(name string, perm os.FileMode) error {
-	p := path.Join(fs.rootPath, name)
+// Dropbox creates the missing parents by itself, so this is a single call.
+func (fs *Fs) MkdirAll(name string, _ os.FileMode) error {
+	p, err := fs.realPath("mkdir", name)
+	if err != nil {
+		return err
+	}
+
+	// The root always exists
+	if p == "/" {
+		return nil
+	}
 
-	parts := strings.Split(p, "/")
-	totalPath := ""
+	_, err = fs.files.CreateFolderV2(&files.CreateFolderArg{Path: p})
 
-	for _, part := range parts {
-		if part == "" {
-			continue
+	if err != nil {
+		// The directory already exists
+		if isFolderConflict(err) {
+			return nil
 		}
 
-		totalPath += "/" + part
-		if _, err := fs.Stat(totalPath); err != nil {
-			if os.IsNotExist(err) {
-				if err = fs.Mkdir(name, perm); err != nil {
-					return err
-				}
-			} else {
-				return err
-			}
+		// A file is in the way, either at the directory path or at one of its parents
+		if isConflict(err) {
+			return &os.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
 		}
+
+		return fmt.Errorf("couldn't create dir: %w", err)
 	}
 
 	return nil
@@ -104,11 +140,33 @@ func (fs *Fs) Open(name string) (afero.File, error) {
 }
 
 // OpenFile opens a file.
-func (fs *Fs) OpenFile(name string, flag int, _ os.FileMode) (afero.File, error) {
-	p := path.Join(fs.rootPath, name)
+// Flags are interpreted like os.OpenFile does, with a few differences:
+// - O_RDWR and O_APPEND are only supported when a spool is set, see SetSpool
+// - writing to a file replaces its whole content
+// - the file is only uploaded when it's closed, if something was written, or if it was created or truncated
+// O_EXCL also makes the upload fail if the file was created in the meantime, see WriteModeAdd.
+func (fs *Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	return fs.openFile(name, flag, perm, nil)
+}
+
+func (fs *Fs) openFile(name string, flag int, _ os.FileMode, opts *WriteOptions) (afero.File, error) {
+	p, err := fs.realPath("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if spool := fs.spoolOptions(); flag&(os.O_RDWR|os.O_APPEND) != 0 && spool != nil {
+		return fs.openSpoolFile(name, p, flag, spool)
+	}
 
 	file := newFile(fs, p)
 
+	if flag&os.O_EXCL != 0 && opts == nil {
+		opts = &WriteOptions{Mode: WriteModeAdd}
+	}
+
+	file.writeOptions = opts
+
 	// Reading and writing is technically supported but can't lead to anything that makes sense
 	if flag&os.O_RDWR != 0 {
 		return nil, ErrNotSupported
@@ -120,14 +178,24 @@ func (fs *Fs) OpenFile(name string, flag int, _ os.FileMode) (afero.File, error)
 		return nil, ErrNotSupported
 	}
 
-	// Creating is basically a write
-	if flag&os.O_CREATE != 0 {
-		flag |= os.O_WRONLY
+	// Creating a file to read it starts with an empty file
+	if flag&os.O_CREATE != 0 && flag&os.O_WRONLY == 0 {
+		if err := file.prepareCreate(name, flag); err != nil {
+			return nil, err
+		}
 	}
 
 	// We either write
 	if flag&os.O_WRONLY != 0 {
-		return file, file.openWriteStream()
+		if err := file.prepareWrite(name, flag); err != nil {
+			return nil, err
+		}
+
+		if opts != nil && opts.Seekable {
+			file.buffer = fs.newWriteBuffer()
+		}
+
+		return file, nil
 	}
 
 	info, err := file.Stat()
@@ -137,37 +205,250 @@ func (fs *Fs) OpenFile(name string, flag int, _ os.FileMode) (afero.File, error)
 	}
 
 	if info.IsDir() {
+		file.state = fileStateDirectory
+
 		return file, nil
 	}
 
 	return file, file.openReadStream(0)
 }
 
-// Remove removes a file.
+// Remove removes a file or an empty directory.
+// Like os.Remove, it refuses to remove a directory that isn't empty.
 func (fs *Fs) Remove(name string) error {
-	_, err := fs.files.DeleteV2(&files.DeleteArg{Path: path.Join(fs.rootPath, name)})
+	p, err := fs.realPath("remove", name)
+	if err != nil {
+		return err
+	}
+
+	if fs.isRootPath(p) {
+		return &os.PathError{Op: "remove", Path: name, Err: ErrRootDirectory}
+	}
 
+	info, err := fs.stat(p)
 	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+		}
+
+		return err
+	}
+
+	if info.IsDir() {
+		empty, errEmpty := fs.isEmptyDir(p)
+		if errEmpty != nil {
+			return errEmpty
+		}
+
+		if !empty {
+			return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+		}
+	}
+
+	if _, err = fs.files.DeleteV2(&files.DeleteArg{Path: p}); err != nil {
+		if isNotFound(err) {
+			return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+		}
+
 		return fmt.Errorf("couldn't remove a file: %w", err)
 	}
 
 	return nil
 }
 
-// RemoveAll removes all files inside a directory.
+// isEmptyDir checks if a directory is empty.
+// The limit of the listing is approximate, so the pages are followed until an entry is found.
+func (fs *Fs) isEmptyDir(p string) (bool, error) {
+	res, err := fs.files.ListFolder(&files.ListFolderArg{Path: p, Limit: 1})
+
+	for {
+		if err != nil {
+			return false, fmt.Errorf("couldn't check directory content: %w", err)
+		}
+
+		if len(res.Entries) > 0 {
+			return false, nil
+		}
+
+		if !res.HasMore {
+			return true, nil
+		}
+
+		res, err = fs.files.ListFolderContinue(&files.ListFolderContinueArg{Cursor: res.Cursor})
+	}
+}
+
+// RemoveAll removes a file or a directory and all its content.
+// Like os.RemoveAll, it doesn't fail if the path doesn't exist.
+// The root directory itself is kept, only its content is removed.
 func (fs *Fs) RemoveAll(name string) error {
-	return fs.Remove(name)
+	p, err := fs.realPath("remove", name)
+	if err != nil {
+		return err
+	}
+
+	if fs.isRootPath(p) {
+		return fs.removeRootContent()
+	}
+
+	_, err = fs.files.DeleteV2(&files.DeleteArg{Path: p})
+
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("couldn't remove all: %w", err)
+	}
+
+	return nil
+}
+
+// removeRootContent removes all the content of the root directory.
+func (fs *Fs) removeRootContent() error {
+	var names []string
+
+	err := fs.listFolder(fs.root(), false, func(meta files.IsMetadata) {
+		names = append(names, fs.relativePath(metadataPath(meta)))
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(names) == 0 {
+		return nil
+	}
+
+	results, err := fs.RemoveBatch(names)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range results {
+		if r.Err != nil && !errors.Is(r.Err, os.ErrNotExist) {
+			return r.Err
+		}
+	}
+
+	return nil
+}
+
+// isRootPath checks if a real path is the root directory.
+func (fs *Fs) isRootPath(p string) bool {
+	return p == "/" || p == "" || p == fs.root()
 }
 
 // Rename renames a file.
+// Like os.Rename, it replaces the destination when it exists, unless the RenameStrict mode is set.
 func (fs *Fs) Rename(oldname, newname string) error {
+	from, err := fs.realPath("rename", oldname)
+	if err != nil {
+		return err
+	}
+
+	to, err := fs.realPath("rename", newname)
+	if err != nil {
+		return err
+	}
+
+	if fs.isRootPath(from) || fs.isRootPath(to) {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: ErrRootDirectory}
+	}
+
+	// Renaming a file to itself does nothing, and a case-only rename can't conflict with another file.
+	// The real paths are already normalized, they can't be rejected.
+	fromKey, _ := ComparablePath(from)
+	toKey, _ := ComparablePath(to)
+	sameFile := fromKey == toKey
+
+	if from == to {
+		if _, err = fs.stat(from); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: os.ErrNotExist}
+			}
+
+			return err
+		}
+
+		return nil
+	}
+
+	err = fs.move(from, to)
+
+	if err != nil && isConflict(err) && !sameFile {
+		if fs.currentRenameMode() == RenameStrict {
+			return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: os.ErrExist}
+		}
+
+		if errRemove := fs.removeRenameDestination(from, to); errRemove != nil {
+			return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: errRemove}
+		}
+
+		err = fs.move(from, to)
+	}
+
+	if err != nil {
+		if isNotFound(err) {
+			return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: os.ErrNotExist}
+		}
+
+		return fmt.Errorf("couldn't rename file: %w", err)
+	}
+
+	return nil
+}
+
+func (fs *Fs) move(from, to string) error {
 	_, err := fs.files.MoveV2(&files.RelocationArg{RelocationPath: files.RelocationPath{
-		FromPath: path.Join(fs.rootPath, oldname),
-		ToPath:   path.Join(fs.rootPath, newname),
+		FromPath: from,
+		ToPath:   to,
 	}})
 
+	return err // nolint: wrapcheck
+}
+
+// removeRenameDestination removes the destination of a rename, following the os.Rename rules:
+// a file can replace a file and a directory can only replace an empty directory.
+func (fs *Fs) removeRenameDestination(from, to string) error {
+	src, err := fs.stat(from)
 	if err != nil {
-		return fmt.Errorf("couldn't rename file: %w", err)
+		return err
+	}
+
+	dst, err := fs.stat(to)
+	if err != nil {
+		// It was removed in the meantime
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return err
+	}
+
+	// The destination must never be the source itself
+	if src.(*FileInfo).PathLower() == dst.(*FileInfo).PathLower() {
+		return os.ErrExist
+	}
+
+	arg := &files.DeleteArg{Path: to}
+
+	switch {
+	case src.IsDir() && !dst.IsDir():
+		return syscall.ENOTDIR
+	case !src.IsDir() && dst.IsDir():
+		return syscall.EISDIR
+	case dst.IsDir():
+		empty, errEmpty := fs.isEmptyDir(to)
+		if errEmpty != nil {
+			return errEmpty
+		}
+
+		if !empty {
+			return syscall.ENOTEMPTY
+		}
+	default:
+		// The rev makes sure we don't delete a file that was modified in the meantime
+		arg.ParentRev = dst.(*FileInfo).Rev()
+	}
+
+	if _, err = fs.files.DeleteV2(arg); err != nil && !isNotFound(err) {
+		return fmt.Errorf("couldn't remove rename destination: %w", err)
 	}
 
 	return nil
@@ -175,13 +456,21 @@ func (fs *Fs) Rename(oldname, newname string) error {
 
 // Stat fetches the file info.
 func (fs *Fs) Stat(name string) (os.FileInfo, error) {
-	p := path.Join(fs.rootPath, name)
+	p, err := fs.realPath("stat", name)
+	if err != nil {
+		return nil, err
+	}
 
 	return fs.stat(p)
 }
 
 func (fs *Fs) stat(name string) (os.FileInfo, error) {
-	meta, err := fs.files.GetMetadata(&files.GetMetadataArg{Path: name})
+	// Dropbox doesn't provide any metadata for its root folder
+	if name == "/" || name == "" {
+		return fs.newFileInfo(rootFolderMetadata()), nil
+	}
+
+	meta, err := fs.files.GetMetadata(&files.GetMetadataArg{Path: name, IncludePropertyGroups: fs.propertyGroupsFilter()})
 
 	if err != nil {
 		var errMetadataAPIError files.GetMetadataAPIError
@@ -192,7 +481,16 @@ func (fs *Fs) stat(name string) (os.FileInfo, error) {
 		return nil, fmt.Errorf("couldn't fetch file info: %w", err)
 	}
 
-	return newFileInfo(meta), nil
+	return fs.newFileInfo(meta), nil
+}
+
+func rootFolderMetadata() *files.FolderMetadata {
+	meta := &files.FolderMetadata{}
+	meta.Name = "/"
+	meta.PathLower = "/"
+	meta.PathDisplay = "/"
+
+	return meta
 }
 
 // Name of the fs: dropbox.
@@ -200,24 +498,189 @@ func (fs *Fs) Name() string {
 	return "dropbox"
 }
 
-// Chmod is not supported.
+// Chmod changes the mode of a file, it's only supported when the POSIX metadata emulation is enabled.
 func (fs *Fs) Chmod(name string, mode os.FileMode) error {
-	return ErrNotSupported
+	if fs.posixTemplateID() == "" {
+		return ErrNotSupported
+	}
+
+	return fs.updatePosixMetadata("chmod", name, func(p *PosixMetadata) {
+		p.setMode(mode)
+	})
 }
 
-// Chown is not supported.
+// Chown changes the owner of a file, it's only supported when the POSIX metadata emulation is enabled.
 func (fs *Fs) Chown(name string, uid int, gid int) error {
-	return ErrNotSupported
+	if fs.posixTemplateID() == "" {
+		return ErrNotSupported
+	}
+
+	return fs.updatePosixMetadata("chown", name, func(p *PosixMetadata) {
+		p.setOwner(uid, gid)
+	})
+}
+
+// Chtimes changes the modification time of a file, the access time is ignored.
+// Dropbox doesn't support simply changing a time, so the current revision of the file is uploaded again
+// with the new time. It fails with ErrConflict if the file was modified in the meantime.
+// Directories don't have any time and aren't supported.
+// When the POSIX metadata emulation is enabled, both times are only stored in its properties.
+func (fs *Fs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	if fs.posixTemplateID() != "" {
+		return fs.updatePosixMetadata("chtimes", name, func(p *PosixMetadata) {
+			p.setTimes(atime, mtime)
+		})
+	}
+
+	p, err := fs.realPath("chtimes", name)
+	if err != nil {
+		return err
+	}
+
+	info, err := fs.stat(p)
+
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return &os.PathError{Op: "chtimes", Path: name, Err: os.ErrNotExist}
+	case err != nil:
+		return err
+	case info.IsDir():
+		return &os.PathError{Op: "chtimes", Path: name, Err: ErrNotSupported}
+	}
+
+	rev := info.(*FileInfo).Rev()
+
+	_, content, err := fs.files.Download(&files.DownloadArg{Path: p, Rev: rev})
+	if err != nil {
+		return fmt.Errorf("couldn't download file: %w", err)
+	}
+
+	defer func() { _ = content.Close() }()
+
+	opts := &WriteOptions{Mode: WriteModeUpdate, Rev: rev, ModTime: mtime}
+
+	transfer := newTransferProgress(fs.relativePath(p), TransferUpload, info.Size())
+	onChunk := func(n int64) { transfer.report(fs.progressFunc(), n, true) }
+
+	upload := throttle(throttle(content, fs.rateLimiters(TransferDownload)), fs.rateLimiters(TransferUpload))
+
+	if _, err = fs.uploadSession(opts.commitInfo(p), upload, onChunk); err != nil {
+		if isConflict(err) {
+			return &os.PathError{Op: "chtimes", Path: name, Err: ErrConflict}
+		}
+
+		return fmt.Errorf("couldn't upload file: %w", err)
+	}
+
+	return nil
+}
+
+// SetRenameMode defines how Rename behaves when the destination already exists.
+func (fs *Fs) SetRenameMode(mode RenameMode) {
+	fs.settings.Lock()
+	defer fs.settings.Unlock()
+
+	fs.renameMode = mode
+}
+
+func (fs *Fs) currentRenameMode() RenameMode {
+	fs.settings.RLock()
+	defer fs.settings.RUnlock()
+
+	return fs.renameMode
+}
+
+// realPath converts a name to its normalized dropbox path inside the root directory.
+// Names are always relative to the root directory, whether they start with a "/" or not,
+// and any name trying to go above it with ".." is rejected.
+func (fs *Fs) realPath(op, name string) (string, error) {
+	normalized, err := NormalizePath(fs.encodePath(name))
+	if err != nil {
+		return "", &os.PathError{Op: op, Path: name, Err: ErrInvalidName}
+	}
+
+	depth := 0
+
+	for _, part := range strings.Split(normalized, "/") {
+		switch part {
+		case "":
+		case "..":
+			if depth--; depth < 0 {
+				return "", &os.PathError{Op: op, Path: name, Err: ErrOutsideRoot}
+			}
+		default:
+			depth++
+		}
+	}
+
+	p := path.Clean(normalized)
+	root := fs.root()
+
+	if p == "/" && root != "" {
+		return root, nil
+	}
+
+	return root + p, nil
 }
 
-// Chtimes is not supported because dropbox doesn't support simply changing a time.
-func (fs *Fs) Chtimes(name string, _ time.Time, mtime time.Time) error {
-	return ErrNotSupported
+// relativePath converts a dropbox path to a path relative to the root directory.
+// Dropbox paths are case-insensitive, so is the root directory.
+// Names are decoded if a NameEncoder is set.
+func (fs *Fs) relativePath(fullPath string) string {
+	return fs.decodePath(fs.encodedRelativePath(fullPath))
+}
+
+func (fs *Fs) encodedRelativePath(fullPath string) string {
+	root := fs.root()
+
+	if len(fullPath) >= len(root) && strings.EqualFold(fullPath[:len(root)], root) {
+		rel := fullPath[len(root):]
+
+		if rel == "" {
+			return "/"
+		}
+
+		if strings.HasPrefix(rel, "/") {
+			return rel
+		}
+	}
+
+	if !strings.HasPrefix(fullPath, "/") {
+		fullPath = "/" + fullPath
+	}
+
+	return fullPath
 }
 
 // SetRootDirectory defines a base directory
 // This is mostly useful to isolate tests and can most probably forgotten
 // for most use-cases.
+// All the names are confined to this directory, they can't go above it.
 func (fs *Fs) SetRootDirectory(fullPath string) {
-	fs.rootPath = fullPath
+	root := path.Clean("/" + fullPath)
+
+	// The root of dropbox is represented by an empty root path
+	if root == "/" {
+		root = ""
+	}
+
+	fs.settings.Lock()
+	defer fs.settings.Unlock()
+
+	fs.rootPath = root
+}
+
+func (fs *Fs) root() string {
+	fs.settings.RLock()
+	defer fs.settings.RUnlock()
+
+	return fs.rootPath
+}
+
+// listLimit returns the maximum number of entries fetched per listing request, 0 means the API default.
+func (fs *Fs) listLimit() int {
+	fs.settings.RLock()
+	defer fs.settings.RUnlock()
+
+	return fs.dirListLimit
 }
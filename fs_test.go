@@ -1,13 +1,18 @@
 package dropbox
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path"
+	"strings"
 	"sync"
+	"syscall"
 	"testing"
 	"time"
 
@@ -60,140 +65,1683 @@ func setup(t *testing.T) (*Fs, *require.Assertions) {
 	token := os.Getenv("DROPBOX_TOKEN")
 	// t.Log("Token: " + token[:4] + "..." + token[len(token)-4:])
 
-	fs := NewFs(token)
+	var fs *Fs
+
+	// Without any token, we're testing against an in-memory fake server
+	if token != "" {
+		fs = NewFs(token)
+	} else {
+		fs = newFakeFs(t)
+	}
 
 	fullPath := "/" + sanitizeName(fmt.Sprintf("Test-%s-%s", t.Name()[4:], suffix))
 
-	err := fs.MkdirAll(fullPath, os.FileMode(700))
-	req.NoError(err)
+	err := fs.MkdirAll(fullPath, os.FileMode(700))
+	req.NoError(err)
+
+	fs.SetRootDirectory(fullPath)
+
+	t.Cleanup(func() {
+		fs.SetRootDirectory("")
+		req.NoError(fs.RemoveAll(fullPath))
+	})
+
+	return fs, req
+}
+
+func sanitizeName(s string) string {
+	runes := []rune(s)
+	for i, r := range runes {
+		if isPathSeperator(r) || r == '\'' {
+			runes[i] = '-'
+		}
+	}
+
+	return string(runes)
+}
+
+func isPathSeperator(r rune) bool {
+	return r == '/' || r == '\\'
+}
+
+func TestCompatibility(t *testing.T) {
+	var _ afero.Fs = (*Fs)(nil)
+	var _ afero.File = (*File)(nil)
+}
+
+func TestGetFs(t *testing.T) {
+	fs, req := setup(t)
+	req.NotNil(fs)
+}
+
+func TestMkdir(t *testing.T) {
+	fs, req := setup(t)
+	req.NoError(fs.Mkdir("dir1", 0))
+	info, err := fs.Stat("dir1")
+	req.NoError(err)
+	req.True(info.IsDir())
+}
+
+func TestMkdirAll(t *testing.T) {
+	fs, req := setup(t)
+
+	req.NoError(fs.MkdirAll("dir1/dir2/dir3", 0))
+
+	for _, p := range []string{"dir1", "dir1/dir2", "dir1/dir2/dir3"} {
+		info, err := fs.Stat(p)
+		req.NoError(err)
+		req.True(info.IsDir())
+	}
+
+	// Creating it again isn't an error
+	req.NoError(fs.MkdirAll("dir1/dir2", 0))
+
+	// But Mkdir can't create an existing directory
+	req.True(os.IsExist(fs.Mkdir("dir1/dir2", 0)))
+
+	f, err := fs.OpenFile("dir1/file1", os.O_WRONLY|os.O_CREATE, 0)
+	req.NoError(err)
+	req.NoError(f.Close())
+
+	// A file can't be a directory
+	req.True(errors.Is(fs.MkdirAll("dir1/file1", 0), syscall.ENOTDIR))
+	req.True(errors.Is(fs.MkdirAll("dir1/file1/dir4", 0), syscall.ENOTDIR))
+
+	// Even when it's an intermediate parent
+	req.True(errors.Is(fs.MkdirAll("dir1/file1/dir4/dir5", 0), syscall.ENOTDIR))
+	req.True(errors.Is(fs.MkdirAll("/dir1/file1/dir4/dir5/dir6", 0), syscall.ENOTDIR))
+
+	_, err = fs.Stat("dir1/file1/dir4")
+	req.True(os.IsNotExist(err))
+}
+
+func TestRootJail(t *testing.T) {
+	fs, req := setup(t)
+
+	req.NoError(fs.Mkdir("dir1", 0))
+
+	f, err := fs.OpenFile("dir1/../file1", os.O_WRONLY|os.O_CREATE, 0)
+	req.NoError(err)
+	req.NoError(f.Close())
+	req.Equal("/file1", f.Name())
+
+	for _, name := range []string{"/file1", "file1", "./file1", "dir1/../file1", "//dir1/./..//file1"} {
+		info, err := fs.Stat(name)
+		req.NoError(err, name)
+		req.Equal("file1", info.Name())
+	}
+
+	for _, name := range []string{"..", "../file1", "/../file1", "dir1/../../file1", "dir1/../../../"} {
+		_, err := fs.Stat(name)
+		req.True(errors.Is(err, ErrOutsideRoot), name)
+
+		_, err = fs.Open(name)
+		req.True(errors.Is(err, ErrOutsideRoot), name)
+
+		req.True(errors.Is(fs.Remove(name), ErrOutsideRoot), name)
+		req.True(errors.Is(fs.Mkdir(name, 0), ErrOutsideRoot), name)
+		req.True(errors.Is(fs.Rename("file1", name), ErrOutsideRoot), name)
+	}
+
+	// The root directory itself can be listed
+	dir, err := fs.Open("/")
+	req.NoError(err)
+
+	names, err := dir.Readdirnames(10)
+	req.NoError(err)
+	req.ElementsMatch([]string{"dir1", "file1"}, names)
+	req.NoError(dir.Close())
+
+	// But it can't be removed or moved
+	for _, name := range []string{"/", "", ".", "dir1/.."} {
+		req.True(errors.Is(fs.Remove(name), ErrRootDirectory), name)
+		req.True(errors.Is(fs.Rename(name, "dir2"), ErrRootDirectory), name)
+		req.True(errors.Is(fs.Rename("file1", name), ErrRootDirectory), name)
+
+		_, err = fs.RemoveBatch([]string{"file1", name})
+		req.True(errors.Is(err, ErrRootDirectory), name)
+
+		_, err = fs.RenameBatch([]BatchRelocation{{From: name, To: "dir2"}})
+		req.True(errors.Is(err, ErrRootDirectory), name)
+
+		_, err = fs.CopyBatch([]BatchRelocation{{From: "file1", To: name}})
+		req.True(errors.Is(err, ErrRootDirectory), name)
+	}
+
+	_, err = fs.Stat("file1")
+	req.NoError(err)
+
+	// Removing everything only removes its content
+	req.NoError(fs.RemoveAll("/"))
+
+	info, err := fs.Stat("/")
+	req.NoError(err)
+	req.True(info.IsDir())
+
+	dir, err = fs.Open("/")
+	req.NoError(err)
+
+	names, err = dir.Readdirnames(0)
+	req.NoError(err)
+	req.Empty(names)
+	req.NoError(dir.Close())
+
+	// Even when it's already empty
+	req.NoError(fs.RemoveAll("/"))
+}
+
+func TestRootFolder(t *testing.T) {
+	fs, req := setup(t)
+
+	req.NoError(fs.Mkdir("dir1", 0))
+
+	f, err := fs.OpenFile("dir1/file1", os.O_WRONLY|os.O_CREATE, 0)
+	req.NoError(err)
+	req.NoError(f.Close())
+
+	{ // Walking the root directory
+		var walked []string
+
+		req.NoError(afero.Walk(fs, "/", func(p string, info os.FileInfo, err error) error {
+			req.NoError(err)
+			walked = append(walked, p)
+
+			return nil
+		}))
+
+		req.Equal([]string{"/", "/dir1", "/dir1/file1"}, walked)
+	}
+
+	{ // Accessing the root of dropbox
+		testDir := path.Base(fs.rootPath)
+		fs.SetRootDirectory("")
+
+		info, err := fs.Stat("/")
+		req.NoError(err)
+		req.True(info.IsDir())
+		req.Equal("/", info.Name())
+
+		dir, err := fs.Open("/")
+		req.NoError(err)
+
+		names, err := dir.Readdirnames(-1)
+		req.NoError(err)
+		req.Contains(names, testDir)
+	}
+}
+
+func TestNormalizePath(t *testing.T) {
+	req := require.New(t)
+
+	for name, expected := range map[string]string{
+		"":                    "/",
+		"/":                   "/",
+		"file1":               "/file1",
+		"//dir1///file1":      "/dir1/file1",
+		`dir1\file1`:          "/dir1/file1",
+		"./dir1/./file1":      "/dir1/file1",
+		"dir1/../file1":       "/dir1/../file1",
+		"Cafe\u0301/Fiche":    "/Caf\u00e9/Fiche",
+		"/dir1/file.with.dot": "/dir1/file.with.dot",
+	} {
+		p, err := NormalizePath(name)
+		req.NoError(err, name)
+		req.Equal(expected, p, name)
+	}
+
+	for _, name := range []string{"file1 ", "dir1./file1", "dir1/file1."} {
+		_, err := NormalizePath(name)
+		req.True(errors.Is(err, ErrInvalidName), name)
+	}
+
+	a, err := ComparablePath("/Dir1//CAFE\u0301")
+	req.NoError(err)
+	b, err := ComparablePath("dir1/caf\u00e9")
+	req.NoError(err)
+	req.Equal(a, b)
+}
+
+func TestPathNormalization(t *testing.T) {
+	fs, req := setup(t)
+
+	req.NoError(fs.Mkdir("Dir1", 0))
+
+	f, err := fs.OpenFile("Dir1//Cafe\u0301.txt", os.O_WRONLY|os.O_CREATE, 0)
+	req.NoError(err)
+	req.NoError(f.Close())
+	req.Equal("/Dir1/Caf\u00e9.txt", f.Name())
+
+	info, err := fs.Stat("dir1\\CAF\u00c9.TXT")
+	req.NoError(err)
+	req.Equal("Caf\u00e9.txt", info.Name())
+	req.Equal(strings.ToLower(info.(*FileInfo).PathDisplay()), info.(*FileInfo).PathLower())
+
+	_, err = fs.Stat("dir1/file1 ")
+	req.True(errors.Is(err, ErrInvalidName))
+}
+
+func TestStandardEncoder(t *testing.T) {
+	req := require.New(t)
+	enc := StandardEncoder{}
+
+	for name, expected := range map[string]string{
+		"file1":          "file1",
+		"file1 ":         "file1\u2420",
+		"file1.":         "file1\uff0e",
+		"file 1.txt":     "file 1.txt",
+		`dir\file1`:      "dir\uff3cfile1",
+		"tab\tfile":      "tab\u2409file",
+		"del\x7f":        "del\u2421",
+		"already\u2420":  "already\u201b\u2420",
+		"quote\u201b":    "quote\u201b\u201b",
+		"mixed\u201b\\ ": "mixed\u201b\u201b\uff3c\u2420",
+	} {
+		encoded := enc.Encode(name)
+		req.Equal(expected, encoded, name)
+		req.Equal(name, enc.Decode(encoded), name)
+	}
+}
+
+func TestNameEncoding(t *testing.T) {
+	fs, req := setup(t)
+	fs.SetNameEncoder(StandardEncoder{})
+
+	names := []string{"file1 ", "file2.", `back\slash`, "control\x01"}
+
+	req.NoError(fs.Mkdir("dir. ", 0))
+
+	for _, name := range names {
+		f, err := fs.OpenFile("dir. /"+name, os.O_WRONLY|os.O_CREATE, 0)
+		req.NoError(err, name)
+		_, err = f.WriteString("content")
+		req.NoError(err)
+		req.NoError(f.Close())
+		req.Equal("/dir. /"+name, f.Name())
+
+		info, err := fs.Stat("dir. /" + name)
+		req.NoError(err, name)
+		req.Equal(name, info.Name())
+	}
+
+	dir, err := fs.Open("dir. ")
+	req.NoError(err)
+
+	listed, err := dir.Readdirnames(-1)
+	req.NoError(err)
+	req.ElementsMatch(names, listed)
+
+	matches, err := fs.Glob("/dir. /file*")
+	req.NoError(err)
+	req.Equal([]string{"/dir. /file1 ", "/dir. /file2."}, matches)
+}
+
+func TestCreateFile(t *testing.T) {
+	fs, req := setup(t)
+
+	f, err := fs.Create("file1")
+	req.NoError(err)
+	req.NotNil(f)
+
+	// Nothing is uploaded before the file is closed
+	_, err = fs.Stat("file1")
+	req.True(os.IsNotExist(err))
+
+	req.NoError(f.Close())
+
+	info, err := fs.Stat("file1")
+	req.NoError(err)
+	req.EqualValues(0, info.Size())
+
+	// Creating an existing file truncates it with a single upload
+	f, err = fs.Create("file1")
+	req.NoError(err)
+
+	_, err = f.WriteString("content")
+	req.NoError(err)
+	req.NoError(f.Close())
+
+	content, err := afero.ReadFile(fs, "file1")
+	req.NoError(err)
+	req.Equal("content", string(content))
+}
+
+func TestRenameFile(t *testing.T) {
+	fs, req := setup(t)
+
+	f, err := fs.Create("file1")
+	req.NoError(err)
+	req.NotNil(f)
+	req.NoError(f.Close())
+
+	err = fs.Rename("file1", "file2")
+	req.NoError(err)
+
+	s, err := fs.Stat("file1")
+	req.EqualError(err, os.ErrNotExist.Error())
+	req.Nil(s)
+
+	s, err = fs.Stat("file2")
+	req.NoError(err)
+	req.False(s.IsDir())
+}
+
+func TestRenameReplace(t *testing.T) {
+	fs, req := setup(t)
+
+	writeFile := func(name, content string) {
+		f, err := fs.OpenFile(name, os.O_WRONLY|os.O_CREATE, 0)
+		req.NoError(err)
+		_, err = f.WriteString(content)
+		req.NoError(err)
+		req.NoError(f.Close())
+	}
+
+	writeFile("file1", "content 1")
+	writeFile("file2", "content 22")
+	req.NoError(fs.Mkdir("dir1", 0))
+	req.NoError(fs.Mkdir("dir2", 0))
+	writeFile("dir2/file3", "content 3")
+
+	{ // A file replaces a file
+		req.NoError(fs.Rename("file1", "file2"))
+
+		info, err := fs.Stat("file2")
+		req.NoError(err)
+		req.Equal(int64(9), info.Size())
+
+		_, err = fs.Stat("file1")
+		req.True(os.IsNotExist(err))
+	}
+
+	{ // A file can't replace a directory
+		err := fs.Rename("file2", "dir1")
+		req.True(errors.Is(err, syscall.EISDIR))
+	}
+
+	{ // A directory can't replace a file
+		err := fs.Rename("dir1", "file2")
+		req.True(errors.Is(err, syscall.ENOTDIR))
+	}
+
+	{ // A directory can't replace a non-empty directory
+		err := fs.Rename("dir1", "dir2")
+		req.True(errors.Is(err, syscall.ENOTEMPTY))
+	}
+
+	{ // But it can replace an empty one
+		req.NoError(fs.Rename("dir2", "dir1"))
+
+		_, err := fs.Stat("dir1/file3")
+		req.NoError(err)
+	}
+
+	{ // Renaming a file to itself does nothing
+		req.NoError(fs.Rename("file2", "file2"))
+
+		info, err := fs.Stat("file2")
+		req.NoError(err)
+		req.Equal(int64(9), info.Size())
+
+		err = fs.Rename("missing", "missing")
+		req.True(os.IsNotExist(err))
+	}
+
+	{ // Changing the case of a name only renames it
+		req.NoError(fs.Rename("file2", "FILE2"))
+
+		info, err := fs.Stat("file2")
+		req.NoError(err)
+		req.Equal("FILE2", info.Name())
+		req.Equal(int64(9), info.Size())
+
+		req.NoError(fs.Rename("FILE2", "file2"))
+	}
+
+	{ // Strict mode refuses to replace anything
+		writeFile("file4", "content 4")
+		fs.SetRenameMode(RenameStrict)
+
+		err := fs.Rename("file4", "file2")
+		req.True(os.IsExist(err))
+
+		info, err := fs.Stat("file2")
+		req.NoError(err)
+		req.Equal(int64(9), info.Size())
+	}
+}
+
+func TestStatFile(t *testing.T) {
+	fs, req := setup(t)
+
+	before := time.Now()
+
+	f, err := fs.OpenFile("file1", os.O_WRONLY|os.O_CREATE, 0)
+	req.NoError(err)
+	req.NotNil(f)
+
+	written, err := f.WriteString("some content")
+	req.NoError(err)
+	req.Equal(12, written)
+
+	// Closing the file
+	req.NoError(f.Close())
+
+	after := time.Now()
+
+	// Using the cache
+	info, err := f.Stat()
+	req.NoError(err)
+	req.Equal(int64(12), info.Size())
+	req.NotNil(info.Sys())
+	req.False(info.IsDir())
+	req.Equal("file1", info.Name())
+	req.Equal(os.FileMode(0777), info.Mode())
+
+	// Not using the cache
+	info, err = fs.Stat("file1")
+	req.NoError(err)
+	req.Equal(int64(12), info.Size())
+	req.True(info.ModTime().After(before))
+	req.True(info.ModTime().Before(after))
+	req.Equal("/file1", f.Name())
+	req.Equal("file1", info.Name())
+
+	// Delete the file
+	req.NoError(fs.Remove("file1"))
+
+	// Let's see if it still exists
+	info, err = fs.Stat("file1")
+	req.EqualError(err, os.ErrNotExist.Error())
+	req.Nil(info)
+}
+
+func TestStatDir(t *testing.T) {
+	fs, req := setup(t)
+
+	req.NoError(fs.Mkdir("dir1", 0))
+
+	dir1, err := fs.Open("dir1")
+	req.NoError(err)
+
+	info, errStat := dir1.Stat()
+	req.NoError(errStat)
+
+	req.True(info.IsDir())
+}
+
+func TestRemove(t *testing.T) {
+	fs, req := setup(t)
+
+	req.NoError(fs.Mkdir("dir1", 0))
+	req.NoError(fs.Mkdir("dir2", 0))
+
+	f, err := fs.OpenFile("dir1/file1", os.O_WRONLY|os.O_CREATE, 0)
+	req.NoError(err)
+	req.NoError(f.Close())
+
+	// A non-empty directory can't be removed
+	err = fs.Remove("dir1")
+	req.True(errors.Is(err, syscall.ENOTEMPTY))
+
+	_, err = fs.Stat("dir1/file1")
+	req.NoError(err)
+
+	// But files and empty directories can
+	req.NoError(fs.Remove("dir1/file1"))
+	req.NoError(fs.Remove("dir1"))
+	req.NoError(fs.Remove("dir2"))
+
+	// Missing files can't
+	err = fs.Remove("dir1")
+	req.True(os.IsNotExist(err))
+}
+
+func TestRemoveAll(t *testing.T) {
+	fs, req := setup(t)
+
+	req.NoError(fs.Mkdir("dir1", 0))
+	req.NoError(fs.Mkdir("dir1/dir2", 0))
+
+	f, err := fs.OpenFile("dir1/dir2/file1", os.O_WRONLY|os.O_CREATE, 0)
+	req.NoError(err)
+	req.NoError(f.Close())
+
+	req.NoError(fs.RemoveAll("dir1"))
+
+	_, err = fs.Stat("dir1")
+	req.True(os.IsNotExist(err))
+
+	// Removing a missing path isn't an error
+	req.NoError(fs.RemoveAll("dir1"))
+}
+
+func TestCopy(t *testing.T) {
+	fs, req := setup(t)
+
+	req.NoError(fs.Mkdir("dir1", 0))
+
+	f, err := fs.OpenFile("dir1/file1", os.O_WRONLY|os.O_CREATE, 0)
+	req.NoError(err)
+	_, err = f.WriteString("some content")
+	req.NoError(err)
+	req.NoError(f.Close())
+
+	{ // Copying a file
+		info, err := fs.Copy("dir1/file1", "file2", nil)
+		req.NoError(err)
+		req.Equal("file2", info.Name())
+		req.Equal(int64(12), info.Size())
+	}
+
+	{ // Copying a directory
+		info, err := fs.Copy("dir1", "dir2", nil)
+		req.NoError(err)
+		req.True(info.IsDir())
+
+		info, err = fs.Stat("dir2/file1")
+		req.NoError(err)
+		req.Equal(int64(12), info.Size())
+	}
+
+	{ // Conflicts
+		_, err := fs.Copy("dir1/file1", "file2", nil)
+		req.True(os.IsExist(err))
+
+		info, err := fs.Copy("dir1/file1", "file2", &CopyOptions{Autorename: true})
+		req.NoError(err)
+		req.NotEqual("file2", info.Name())
+	}
+
+	{ // Missing source
+		_, err := fs.Copy("missing", "file3", nil)
+		req.True(os.IsNotExist(err))
+	}
+}
+
+func TestBatch(t *testing.T) {
+	fs, req := setup(t)
+
+	// The batches are split in several requests
+	fs.batchSize = 2
+
+	for i := 0; i < 3; i++ {
+		f, err := fs.OpenFile(fmt.Sprintf("file_%d", i), os.O_WRONLY|os.O_CREATE, 0)
+		req.NoError(err)
+		req.NoError(f.Close())
+	}
+
+	{ // Copying
+		results, err := fs.CopyBatch([]BatchRelocation{
+			{From: "file_0", To: "copy/file_0"},
+			{From: "file_1", To: "copy/file_1"},
+			{From: "missing", To: "copy/missing"},
+		})
+		req.NoError(err)
+		req.Len(results, 3)
+		req.NoError(results[0].Err)
+		req.Equal("file_0", results[0].Info.Name())
+		req.NoError(results[1].Err)
+		req.True(os.IsNotExist(results[2].Err))
+	}
+
+	{ // Moving
+		results, err := fs.RenameBatch([]BatchRelocation{
+			{From: "file_2", To: "moved/file_2"},
+			{From: "file_0", To: "copy/file_0"},
+		})
+		req.NoError(err)
+		req.Len(results, 2)
+		req.NoError(results[0].Err)
+		req.True(os.IsExist(results[1].Err))
+
+		_, err = fs.Stat("moved/file_2")
+		req.NoError(err)
+	}
+
+	{ // Removing
+		results, err := fs.RemoveBatch([]string{"copy", "moved/file_2", "missing"})
+		req.NoError(err)
+		req.Len(results, 3)
+		req.NoError(results[0].Err)
+		req.NoError(results[1].Err)
+		req.True(os.IsNotExist(results[2].Err))
+
+		_, err = fs.Stat("copy/file_0")
+		req.True(os.IsNotExist(err))
+	}
+
+	{ // When a request fails, the results of the previous ones are returned with the error
+		client := fs.files
+		fs.files = &limitedBatchClient{Client: client, batches: 1}
+		fs.batchSize = 1
+
+		results, err := fs.CopyBatch([]BatchRelocation{
+			{From: "file_1", To: "partial/file_1"},
+			{From: "file_1", To: "partial/file_2"},
+		})
+		req.True(errors.Is(err, errBatchRefused))
+		req.Len(results, 1)
+		req.NoError(results[0].Err)
+		req.Equal("file_1", results[0].Info.Name())
+
+		fs.files = &limitedBatchClient{Client: client, batches: 1}
+
+		results, err = fs.RemoveBatch([]string{"partial/file_1", "file_1"})
+		req.True(errors.Is(err, errBatchRefused))
+		req.Len(results, 1)
+		req.NoError(results[0].Err)
+
+		_, err = fs.Stat("file_1")
+		req.NoError(err)
+
+		fs.files = client
+		fs.batchSize = 2
+	}
+
+	{ // Empty batches
+		_, err := fs.RemoveBatch(nil)
+		req.True(errors.Is(err, ErrEmptyBatch))
+
+		_, err = fs.RenameBatch(nil)
+		req.True(errors.Is(err, ErrEmptyBatch))
+
+		_, err = fs.CopyBatch([]BatchRelocation{})
+		req.True(errors.Is(err, ErrEmptyBatch))
+	}
+}
+
+func TestOpenFileFlags(t *testing.T) {
+	fs, req := setup(t)
+
+	{ // Without O_CREATE, a file must exist
+		_, err := fs.OpenFile("file1", os.O_WRONLY, 0)
+		req.True(os.IsNotExist(err))
+	}
+
+	{ // With O_CREATE, an empty file is created even if nothing is written
+		f, err := fs.OpenFile("file1", os.O_WRONLY|os.O_CREATE, 0)
+		req.NoError(err)
+		req.NoError(f.Close())
+
+		info, err := fs.Stat("file1")
+		req.NoError(err)
+		req.Equal(int64(0), info.Size())
+	}
+
+	{ // Writing some content
+		f, err := fs.OpenFile("file1", os.O_WRONLY, 0)
+		req.NoError(err)
+		_, err = f.WriteString("content")
+		req.NoError(err)
+		req.NoError(f.Close())
+	}
+
+	{ // O_EXCL refuses existing files
+		_, err := fs.OpenFile("file1", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0)
+		req.True(os.IsExist(err))
+	}
+
+	{ // Closing without writing doesn't change the file
+		f, err := fs.OpenFile("file1", os.O_WRONLY|os.O_CREATE, 0)
+		req.NoError(err)
+		req.NoError(f.Close())
+
+		info, err := fs.Stat("file1")
+		req.NoError(err)
+		req.Equal(int64(7), info.Size())
+	}
+
+	{ // Unless it was truncated
+		f, err := fs.OpenFile("file1", os.O_WRONLY|os.O_TRUNC, 0)
+		req.NoError(err)
+		req.NoError(f.Close())
+
+		info, err := fs.Stat("file1")
+		req.NoError(err)
+		req.Equal(int64(0), info.Size())
+	}
+
+	{ // Opening for reading with O_CREATE keeps an existing file
+		f, err := fs.OpenFile("file1", os.O_RDONLY|os.O_CREATE, 0)
+		req.NoError(err)
+		content, err := io.ReadAll(f)
+		req.NoError(err)
+		req.Empty(content)
+		req.NoError(f.Close())
+
+		req.NoError(afero.WriteFile(fs, "file1", []byte("content"), 0))
+
+		f, err = fs.OpenFile("file1", os.O_RDONLY|os.O_CREATE, 0)
+		req.NoError(err)
+		content, err = io.ReadAll(f)
+		req.NoError(err)
+		req.Equal("content", string(content))
+		req.NoError(f.Close())
+	}
+
+	{ // And creates an empty one if it's missing
+		f, err := fs.OpenFile("file2", os.O_RDONLY|os.O_CREATE, 0)
+		req.NoError(err)
+		content, err := io.ReadAll(f)
+		req.NoError(err)
+		req.Empty(content)
+		req.NoError(f.Close())
+
+		info, err := fs.Stat("file2")
+		req.NoError(err)
+		req.Equal(int64(0), info.Size())
+
+		_, err = fs.OpenFile("file2", os.O_RDONLY|os.O_CREATE|os.O_EXCL, 0)
+		req.True(os.IsExist(err))
+	}
+
+	{ // Directories can't be written
+		req.NoError(fs.Mkdir("dir1", 0))
+
+		_, err := fs.OpenFile("dir1", os.O_WRONLY|os.O_CREATE, 0)
+		req.True(errors.Is(err, syscall.EISDIR))
+	}
+}
+
+func TestWriteModes(t *testing.T) {
+	fs, req := setup(t)
+
+	writeFile := func(name string, flag int, opts *WriteOptions, content string) (afero.File, error) {
+		f, err := fs.OpenFileWithOptions(name, flag, 0, opts)
+		req.NoError(err)
+		_, err = f.WriteString(content)
+		req.NoError(err)
+
+		return f, f.Close()
+	}
+
+	_, err := writeFile("file1", os.O_WRONLY|os.O_CREATE, nil, "content 1")
+	req.NoError(err)
+
+	{ // Exclusive creation
+		_, err = fs.OpenFile("file1", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0)
+		req.True(os.IsExist(err))
+
+		_, err = writeFile("file1", os.O_WRONLY, &WriteOptions{Mode: WriteModeAdd}, "content 2")
+		req.True(os.IsExist(err))
+	}
+
+	{ // Automatic renaming
+		f, err := writeFile("file1", os.O_WRONLY, &WriteOptions{Mode: WriteModeAddRename}, "content 2")
+		req.NoError(err)
+		req.Equal("/file1 (1)", f.Name())
+
+		info, err := f.Stat()
+		req.NoError(err)
+		req.Equal("file1 (1)", info.Name())
+	}
+
+	{ // Updating a known revision
+		info, err := fs.Stat("file1")
+		req.NoError(err)
+
+		rev := info.(*FileInfo).Rev()
+		req.NotEmpty(rev)
+
+		_, err = writeFile("file1", os.O_WRONLY, &WriteOptions{Mode: WriteModeUpdate, Rev: rev}, "content 3")
+		req.NoError(err)
+
+		// The revision changed, a second update fails
+		_, err = writeFile("file1", os.O_WRONLY, &WriteOptions{Mode: WriteModeUpdate, Rev: rev}, "content 4")
+		req.True(errors.Is(err, ErrConflict))
+
+		info, err = fs.Stat("file1")
+		req.NoError(err)
+		req.NotEqual(rev, info.(*FileInfo).Rev())
+	}
+}
+
+func TestSeekableWrite(t *testing.T) {
+	fs, req := setup(t)
+
+	// Small sizes to go through upload sessions and spill files
+	spool := afero.NewMemMapFs()
+	fs.SetSpool(&SpoolOptions{Fs: spool, Dir: "spool"})
+	fs.uploadChunkSize = 4
+	fs.writeBufferMemory = 8
+
+	{ // Out of order writes
+		f, err := fs.OpenFileWithOptions("file1", os.O_WRONLY|os.O_CREATE, 0, &WriteOptions{Seekable: true})
+		req.NoError(err)
+
+		_, err = f.WriteAt([]byte("world"), 6)
+		req.NoError(err)
+
+		_, err = f.WriteAt([]byte("hello "), 0)
+		req.NoError(err)
+
+		pos, err := f.Seek(0, io.SeekEnd)
+		req.NoError(err)
+		req.EqualValues(11, pos)
+
+		_, err = f.WriteString(", how are you?")
+		req.NoError(err)
+
+		req.NoError(f.Truncate(17))
+
+		_, err = f.Seek(-1, io.SeekStart)
+		req.True(errors.Is(err, ErrInvalidSeek))
+
+		_, err = f.WriteAt([]byte("x"), -1)
+		req.True(errors.Is(err, os.ErrInvalid))
+
+		spilled, err := afero.ReadDir(spool, "spool")
+		req.NoError(err)
+		req.Len(spilled, 1)
+
+		req.NoError(f.Close())
+
+		info, err := f.Stat()
+		req.NoError(err)
+		req.EqualValues(17, info.Size())
+
+		content, err := afero.ReadFile(fs, "file1")
+		req.NoError(err)
+		req.Equal("hello world, how ", string(content))
+
+		spilled, err = afero.ReadDir(spool, "spool")
+		req.NoError(err)
+		req.Empty(spilled)
+	}
+
+	{ // Gaps are filled with zeros and small files are uploaded directly
+		f, err := fs.OpenFileWithOptions("file2", os.O_WRONLY|os.O_CREATE, 0, &WriteOptions{Seekable: true})
+		req.NoError(err)
+
+		_, err = f.WriteAt([]byte("b"), 2)
+		req.NoError(err)
+		req.NoError(f.Close())
+
+		content, err := afero.ReadFile(fs, "file2")
+		req.NoError(err)
+		req.Equal("\x00\x00b", string(content))
+	}
+
+	{ // The write mode still applies
+		f, err := fs.OpenFileWithOptions("file1", os.O_WRONLY, 0, &WriteOptions{Mode: WriteModeAdd, Seekable: true})
+		req.NoError(err)
+
+		_, err = f.WriteString("replaced content")
+		req.NoError(err)
+		req.True(os.IsExist(f.Close()))
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	fs, req := setup(t)
+
+	fs.uploadChunkSize = 4
+
+	req.NoError(afero.WriteFile(fs, "file1", []byte("hello world"), 0))
+
+	readFile := func() string {
+		content, err := afero.ReadFile(fs, "file1")
+		req.NoError(err)
+
+		return string(content)
+	}
+
+	req.NoError(fs.Truncate("file1", 5))
+	req.Equal("hello", readFile())
+
+	req.NoError(fs.Truncate("file1", 7))
+	req.Equal("hello\x00\x00", readFile())
+
+	req.NoError(fs.Truncate("file1", 0))
+	req.Equal("", readFile())
+
+	{ // Through a file
+		req.NoError(afero.WriteFile(fs, "file1", []byte("hello world"), 0))
+
+		// Files opened for reading can't be truncated
+		f, err := fs.Open("file1")
+		req.NoError(err)
+		req.True(errors.Is(f.Truncate(2), os.ErrInvalid))
+		req.NoError(f.Close())
+		req.Equal("hello world", readFile())
+
+		// Streamed files can only be emptied before being written
+		f, err = fs.OpenFile("file1", os.O_WRONLY, 0)
+		req.NoError(err)
+		req.True(errors.Is(f.Truncate(1), ErrNotSupported))
+		req.True(errors.Is(f.Truncate(-1), os.ErrInvalid))
+		req.NoError(f.Truncate(0))
+		req.NoError(f.Close())
+		req.Equal("", readFile())
+
+		f, err = fs.OpenFile("file1", os.O_WRONLY, 0)
+		req.NoError(err)
+		_, err = f.WriteString("hello")
+		req.NoError(err)
+		req.True(errors.Is(f.Truncate(0), ErrNotSupported))
+		req.NoError(f.Close())
+		req.Equal("hello", readFile())
+	}
+
+	req.True(os.IsNotExist(fs.Truncate("missing", 0)))
+	req.True(errors.Is(fs.Truncate("file1", -1), os.ErrInvalid))
+
+	req.NoError(fs.Mkdir("dir1", 0))
+	req.True(errors.Is(fs.Truncate("dir1", 0), syscall.EISDIR))
+}
+
+func TestChtimes(t *testing.T) {
+	fs, req := setup(t)
+
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+
+	req.NoError(afero.WriteFile(fs, "file1", []byte("content"), 0))
+	req.NoError(fs.Chtimes("file1", time.Now(), mtime))
+
+	info, err := fs.Stat("file1")
+	req.NoError(err)
+	req.True(mtime.Truncate(time.Second).Equal(info.ModTime()))
+
+	content, err := afero.ReadFile(fs, "file1")
+	req.NoError(err)
+	req.Equal("content", string(content))
+
+	{ // On a file opened for writing
+		f, err := fs.OpenFile("file2", os.O_WRONLY|os.O_CREATE, 0)
+		req.NoError(err)
+		req.NoError(f.(*File).SetModTime(mtime))
+
+		_, err = f.WriteString("content")
+		req.NoError(err)
+		req.True(errors.Is(f.(*File).SetModTime(mtime), ErrAlreadyOpened))
+		req.NoError(f.Close())
+
+		info, err = fs.Stat("file2")
+		req.NoError(err)
+		req.True(mtime.Truncate(time.Second).Equal(info.ModTime()))
+	}
+
+	req.True(os.IsNotExist(fs.Chtimes("missing", time.Now(), mtime)))
+
+	req.NoError(fs.Mkdir("dir1", 0))
+	req.True(errors.Is(fs.Chtimes("dir1", time.Now(), mtime), ErrNotSupported))
+}
+
+func TestPosixMetadata(t *testing.T) {
+	fs, req := setup(t)
+
+	req.True(errors.Is(fs.Chmod("file1", 0600), ErrNotSupported))
+	req.NoError(fs.EnablePosixMetadata())
+
+	{ // The template is only created once
+		template := fs.posixTemplate
+		req.NoError(fs.EnablePosixMetadata())
+		req.Equal(template, fs.posixTemplate)
+	}
+
+	req.NoError(afero.WriteFile(fs, "file1", []byte("content"), 0))
+	req.NoError(fs.Mkdir("dir1", 0))
+
+	info, err := fs.Stat("file1")
+	req.NoError(err)
+	req.Equal(os.FileMode(simulatedFileMode), info.Mode())
+
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	atime := mtime.Add(time.Hour)
+
+	req.NoError(fs.Chmod("file1", 0640|os.ModeSetuid))
+	req.NoError(fs.Chown("file1", 1000, 100))
+	req.NoError(fs.Chtimes("file1", atime, mtime))
+	req.NoError(fs.Chmod("dir1", 0700))
+
+	info, err = fs.Stat("file1")
+	req.NoError(err)
+	req.Equal(0640|os.ModeSetuid, info.Mode())
+	req.True(mtime.Equal(info.ModTime()))
+
+	posix, ok := info.Sys().(*PosixMetadata)
+	req.True(ok)
+	req.Equal(1000, posix.UID)
+	req.Equal(100, posix.GID)
+	req.True(atime.Equal(posix.Atime))
+	req.NotNil(posix.Metadata)
+
+	// The metadata is kept when the file is written and returned by listings
+	req.NoError(afero.WriteFile(fs, "file1", []byte("new content"), 0))
+
+	infos, err := afero.ReadDir(fs, "/")
+	req.NoError(err)
+	req.Len(infos, 2)
+	req.Equal(os.FileMode(0700), infos[0].Mode())
+	req.Equal(0640|os.ModeSetuid, infos[1].Mode())
+
+	req.True(os.IsNotExist(fs.Chmod("missing", 0600)))
+
+	{ // Zero values are stored too
+		req.NoError(afero.WriteFile(fs, "file2", []byte("content"), 0))
+		req.NoError(fs.Chmod("file2", 0))
+		req.NoError(fs.Chown("file2", 0, 0))
+
+		info, err := fs.Stat("file2")
+		req.NoError(err)
+		req.Equal(os.FileMode(0), info.Mode())
+
+		posix := info.Sys().(*PosixMetadata)
+		req.True(posix.HasMode())
+		req.True(posix.HasOwner())
+		req.False(posix.HasTimes())
+		req.Equal(0, posix.UID)
+
+		// The fields that were never set are reported as such
+		info, err = fs.Stat("dir1")
+		req.NoError(err)
+		req.False(info.Sys().(*PosixMetadata).HasOwner())
+	}
+
+	{ // Concurrent updates of different fields are all kept
+		req.NoError(afero.WriteFile(fs, "file3", []byte("content"), 0))
+
+		p, err := fs.realPath("chmod", "file3")
+		req.NoError(err)
+
+		// Both updates start from the same metadata, which has no properties group yet
+		stat := func() *PosixMetadata {
+			info, err := fs.stat(p)
+			req.NoError(err)
+
+			return info.(*FileInfo).posix
+		}
+
+		first, second := stat(), stat()
+
+		first.setMode(0600)
+		second.setOwner(1000, 100)
+		req.NoError(fs.storePosixMetadata(p, first))
+		req.NoError(fs.storePosixMetadata(p, second))
+
+		third := stat()
+		third.setTimes(atime, mtime)
+		req.NoError(fs.storePosixMetadata(p, third))
+
+		posix := stat()
+		req.True(posix.HasMode())
+		req.True(posix.HasOwner())
+		req.True(posix.HasTimes())
+		req.Equal(os.FileMode(0600), posix.Mode)
+		req.Equal(1000, posix.UID)
+	}
+}
+
+func TestSpool(t *testing.T) {
+	fs, req := setup(t)
+
+	_, err := fs.OpenFile("file1", os.O_RDWR|os.O_CREATE, 0)
+	req.True(errors.Is(err, ErrNotSupported))
+
+	fs.SetSpool(&SpoolOptions{Fs: afero.NewMemMapFs(), MaxSize: 20})
+
+	// The spooled files are committed through upload sessions
+	fs.uploadChunkSize = 4
+
+	{ // Read-write handle on a new file
+		f, err := fs.OpenFile("file1", os.O_RDWR|os.O_CREATE, 0)
+		req.NoError(err)
+
+		_, err = f.WriteString("hello world")
+		req.NoError(err)
+
+		_, err = f.Seek(6, io.SeekStart)
+		req.NoError(err)
+
+		buffer := make([]byte, 5)
+		_, err = io.ReadFull(f, buffer)
+		req.NoError(err)
+		req.Equal("world", string(buffer))
+
+		_, err = f.WriteAt([]byte("W"), 6)
+		req.NoError(err)
+
+		req.NoError(f.Truncate(9))
+		req.NoError(f.Sync())
+
+		_, err = f.Seek(0, io.SeekEnd)
+		req.NoError(err)
+
+		_, err = f.WriteString("!")
+		req.NoError(err)
+		req.NoError(f.Close())
+
+		content, err := afero.ReadFile(fs, "file1")
+		req.NoError(err)
+		req.Equal("hello Wor!", string(content))
+	}
+
+	{ // Append handle on an existing file
+		f, err := fs.OpenFile("file1", os.O_WRONLY|os.O_APPEND, 0)
+		req.NoError(err)
+
+		_, err = f.WriteString(" more")
+		req.NoError(err)
+
+		info, err := f.Stat()
+		req.NoError(err)
+		req.Equal("file1", info.Name())
+		req.EqualValues(15, info.Size())
+		req.NoError(f.Close())
+
+		content, err := afero.ReadFile(fs, "file1")
+		req.NoError(err)
+		req.Equal("hello Wor! more", string(content))
+	}
+
+	{ // The size is limited
+		f, err := fs.OpenFile("file1", os.O_RDWR, 0)
+		req.NoError(err)
+
+		_, err = f.WriteAt([]byte("too long"), 15)
+		req.True(errors.Is(err, ErrSpoolTooLarge))
+		req.NoError(f.Close())
+	}
+
+	{ // The file was modified in the meantime
+		f, err := fs.OpenFile("file1", os.O_RDWR, 0)
+		req.NoError(err)
+
+		_, err = f.WriteString("local")
+		req.NoError(err)
+
+		req.NoError(afero.WriteFile(fs, "file1", []byte("remote"), 0))
+		req.True(errors.Is(f.Close(), ErrConflict))
+
+		content, err := afero.ReadFile(fs, "file1")
+		req.NoError(err)
+		req.Equal("remote", string(content))
+	}
+
+	{ // Existence checks
+		_, err = fs.OpenFile("missing", os.O_RDWR, 0)
+		req.True(os.IsNotExist(err))
+
+		_, err = fs.OpenFile("file1", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0)
+		req.True(os.IsExist(err))
+
+		req.NoError(fs.Mkdir("dir1", 0))
+		_, err = fs.OpenFile("dir1", os.O_RDWR, 0)
+		req.True(errors.Is(err, syscall.EISDIR))
+	}
+}
+
+func TestUploadError(t *testing.T) {
+	fs, req := setup(t)
+
+	req.NoError(afero.WriteFile(fs, "file1", []byte("content"), 0))
+
+	f, err := fs.OpenFileWithOptions("file1", os.O_WRONLY, 0, &WriteOptions{Mode: WriteModeAdd})
+	req.NoError(err)
+
+	// The upload fails while we're still writing
+	chunk := make([]byte, 64*1024)
+
+	for i := 0; i < 1024 && err == nil; i++ {
+		_, err = f.Write(chunk)
+	}
+
+	req.True(os.IsExist(err))
+	req.Contains(err.Error(), "file1")
+
+	err = f.Close()
+	req.True(os.IsExist(err))
+}
+
+func TestFileStates(t *testing.T) {
+	fs, req := setup(t)
+
+	buffer := make([]byte, 10)
+
+	{ // Writing
+		f, err := fs.OpenFile("file1", os.O_WRONLY|os.O_CREATE, 0)
+		req.NoError(err)
+
+		_, err = f.Read(buffer)
+		req.True(errors.Is(err, os.ErrInvalid))
+
+		_, err = f.Readdir(0)
+		req.True(errors.Is(err, syscall.ENOTDIR))
+
+		_, err = f.WriteString("content")
+		req.NoError(err)
+		req.NoError(f.Close())
+
+		req.Equal(afero.ErrFileClosed, f.Close())
+		req.Equal(afero.ErrFileClosed, f.Sync())
+
+		_, err = f.WriteString("content")
+		req.Equal(afero.ErrFileClosed, err)
+
+		info, err := f.Stat()
+		req.NoError(err)
+		req.EqualValues(7, info.Size())
+	}
+
+	{ // Reading
+		f, err := fs.Open("file1")
+		req.NoError(err)
+
+		_, err = f.WriteString("content")
+		req.True(errors.Is(err, os.ErrInvalid))
+
+		_, err = f.Readdirnames(0)
+		req.True(errors.Is(err, syscall.ENOTDIR))
+
+		n, err := io.ReadFull(f, buffer[:7])
+		req.NoError(err)
+		req.Equal("content", string(buffer[:n]))
+		req.NoError(f.Close())
+
+		req.Equal(afero.ErrFileClosed, f.Close())
+
+		_, err = f.Read(buffer)
+		req.Equal(afero.ErrFileClosed, err)
+
+		_, err = f.Seek(0, io.SeekStart)
+		req.Equal(afero.ErrFileClosed, err)
+	}
+
+	{ // Directory
+		req.NoError(fs.Mkdir("dir1", 0))
+
+		f, err := fs.Open("dir1")
+		req.NoError(err)
+
+		_, err = f.Read(buffer)
+		req.True(errors.Is(err, syscall.EISDIR))
+
+		_, err = f.WriteString("content")
+		req.True(errors.Is(err, syscall.EISDIR))
+
+		_, err = f.Seek(0, io.SeekStart)
+		req.True(errors.Is(err, syscall.EISDIR))
+
+		req.True(errors.Is(f.Truncate(0), syscall.EISDIR))
+
+		_, err = f.Readdir(0)
+		req.NoError(err)
+		req.NoError(f.Close())
+
+		_, err = f.Readdir(0)
+		req.Equal(afero.ErrFileClosed, err)
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	fs, req := setup(t)
+
+	const workers = 8
 
-	fs.SetRootDirectory(fullPath)
+	var wg sync.WaitGroup
 
-	t.Cleanup(func() {
-		fs.SetRootDirectory("")
-		req.NoError(fs.RemoveAll(fullPath))
-	})
+	errs := make(chan error, workers*4)
 
-	return fs, req
-}
+	// The settings can change while the Fs is used
+	wg.Add(1)
 
-func sanitizeName(s string) string {
-	runes := []rune(s)
-	for i, r := range runes {
-		if isPathSeperator(r) || r == '\'' {
-			runes[i] = '-'
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < 20; i++ {
+			fs.SetRenameMode(RenameMode(i % 2))
+			fs.SetNameEncoder(nil)
+			fs.SetSpool(nil)
 		}
+	}()
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			name := fmt.Sprintf("file%d", i)
+			content := []byte(strings.Repeat(name, 1000))
+
+			if err := afero.WriteFile(fs, name, content, 0); err != nil {
+				errs <- err
+
+				return
+			}
+
+			if read, err := afero.ReadFile(fs, name); err != nil || string(read) != string(content) {
+				errs <- fmt.Errorf("couldn't read %s: %w", name, err)
+			}
+
+			if _, err := afero.ReadDir(fs, "/"); err != nil {
+				errs <- err
+			}
+		}(i)
 	}
 
-	return string(runes)
-}
+	wg.Wait()
+	close(errs)
 
-func isPathSeperator(r rune) bool {
-	return r == '/' || r == '\\'
-}
+	for err := range errs {
+		req.NoError(err)
+	}
 
-func TestCompatibility(t *testing.T) {
-	var _ afero.Fs = (*Fs)(nil)
-	var _ afero.File = (*File)(nil)
-}
+	{ // A file can be shared
+		f, err := fs.Create("shared")
+		req.NoError(err)
 
-func TestGetFs(t *testing.T) {
-	fs, req := setup(t)
-	req.NotNil(fs)
-}
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
 
-func TestMkdir(t *testing.T) {
-	fs, req := setup(t)
-	req.NoError(fs.Mkdir("dir1", 0))
-	info, err := fs.Stat("dir1")
-	req.NoError(err)
-	req.True(info.IsDir())
-}
+			go func() {
+				defer wg.Done()
 
-func TestCreateFile(t *testing.T) {
-	fs, req := setup(t)
+				_, _ = f.Stat()
+				_ = f.Name()
+				_, _ = f.WriteString("0123456789")
+			}()
+		}
 
-	f, err := fs.Create("file1")
-	req.NoError(err)
-	req.NotNil(f)
+		wg.Wait()
+		req.NoError(f.Close())
+
+		info, err := f.Stat()
+		req.NoError(err)
+		req.EqualValues(10*workers, info.Size())
+
+		f, err = fs.Open("shared")
+		req.NoError(err)
+
+		read := make(chan int, workers)
+
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				n, _ := io.ReadFull(f, make([]byte, 10))
+				read <- n
+			}()
+		}
+
+		wg.Wait()
+		close(read)
+
+		total := 0
+		for n := range read {
+			total += n
+		}
+
+		req.Equal(10*workers, total)
+		req.NoError(f.Close())
+	}
 }
 
-func TestRenameFile(t *testing.T) {
+func TestProgress(t *testing.T) {
 	fs, req := setup(t)
 
-	f, err := fs.Create("file1")
-	req.NoError(err)
-	req.NotNil(f)
+	fs.uploadChunkSize = 4
 
-	err = fs.Rename("file1", "file2")
-	req.NoError(err)
+	var events []Progress
 
-	s, err := fs.Stat("file1")
-	req.EqualError(err, os.ErrNotExist.Error())
-	req.Nil(s)
+	fs.SetProgressFunc(func(progress Progress) { events = append(events, progress) })
 
-	s, err = fs.Stat("file2")
-	req.NoError(err)
-	req.False(s.IsDir())
+	{ // Streamed upload
+		req.NoError(afero.WriteFile(fs, "file1", []byte("hello"), 0600))
+		req.NotEmpty(events)
+
+		last := events[len(events)-1]
+		req.Equal(TransferUpload, last.Direction)
+		req.Equal("/file1", last.Name)
+		req.EqualValues(5, last.Transferred)
+		req.EqualValues(-1, last.Total)
+	}
+
+	{ // Download
+		events = nil
+
+		content, err := afero.ReadFile(fs, "file1")
+		req.NoError(err)
+		req.Equal("hello", string(content))
+		req.NotEmpty(events)
+
+		last := events[len(events)-1]
+		req.Equal(TransferDownload, last.Direction)
+		req.EqualValues(5, last.Transferred)
+		req.EqualValues(5, last.Total)
+	}
+
+	{ // Upload session chunks
+		events = nil
+
+		f, err := fs.OpenFileWithOptions("file2", os.O_WRONLY|os.O_CREATE, 0, &WriteOptions{Seekable: true})
+		req.NoError(err)
+
+		_, err = f.WriteString("0123456789")
+		req.NoError(err)
+		req.NoError(f.Close())
+
+		transferred := make([]int64, 0, len(events))
+
+		for _, event := range events {
+			req.True(event.ChunkDone)
+			req.EqualValues(10, event.Total)
+			transferred = append(transferred, event.Transferred)
+		}
+
+		req.Equal([]int64{4, 8, 10}, transferred)
+	}
+
+	{ // Handle override
+		events = nil
+
+		var fileEvents []Progress
+
+		f, err := fs.Open("file1")
+		req.NoError(err)
+
+		f.(*File).SetProgressFunc(func(progress Progress) { fileEvents = append(fileEvents, progress) })
+
+		_, err = io.ReadAll(f)
+		req.NoError(err)
+		req.NoError(f.Close())
+
+		req.Empty(events)
+		req.NotEmpty(fileEvents)
+	}
+
+	{ // The rate of a resumed transfer only counts the bytes sent since it resumed
+		transfer := newTransferProgress("/file3", TransferUpload, 20)
+		transfer.start = time.Now().Add(-time.Second)
+		transfer.resume(16)
+
+		var last Progress
+
+		transfer.report(func(progress Progress) { last = progress }, 4, true)
+		req.EqualValues(20, last.Transferred)
+		req.InDelta(4, last.Rate, 0.5)
+	}
 }
 
-func TestStatFile(t *testing.T) {
+func TestRateLimits(t *testing.T) {
 	fs, req := setup(t)
 
-	before := time.Now()
+	content := make([]byte, 4*throttleQuantum)
 
-	f, err := fs.OpenFile("file1", os.O_WRONLY, 0)
-	req.NoError(err)
-	req.NotNil(f)
+	{ // Per file write limit, the first slice is sent right away
+		f, err := fs.Create("file1")
+		req.NoError(err)
 
-	written, err := f.WriteString("some content")
-	req.NoError(err)
-	req.Equal(12, written)
+		f.(*File).SetRateLimits(0, 8*throttleQuantum)
 
-	// Closing the file
-	req.NoError(f.Close())
+		start := time.Now()
 
-	after := time.Now()
+		_, err = f.Write(content)
+		req.NoError(err)
+		req.NoError(f.Close())
+		req.GreaterOrEqual(time.Since(start), 375*time.Millisecond)
+	}
 
-	// Using the cache
-	info, err := f.Stat()
-	req.NoError(err)
-	req.Equal(int64(12), info.Size())
-	req.NotNil(info.Sys())
-	req.False(info.IsDir())
-	req.Equal("file1", info.Name())
-	req.Equal(os.FileMode(0777), info.Mode())
+	req.NoError(afero.WriteFile(fs, "file2", content, 0600))
 
-	// Not using the cache
-	info, err = fs.Stat("file1")
-	req.NoError(err)
-	req.Equal(int64(12), info.Size())
-	req.True(info.ModTime().After(before))
-	req.True(info.ModTime().Before(after))
-	req.Equal(path.Join(fs.rootPath, "file1"), f.Name())
-	req.Equal("file1", info.Name())
+	{ // Global read limit, shared by concurrent downloads
+		fs.SetRateLimits(8*throttleQuantum, 0)
 
-	// Delete the file
-	req.NoError(fs.Remove("file1"))
+		start := time.Now()
+		durations := make([]time.Duration, 2)
+		errs := make(chan error, 2)
 
-	// Let's see if it still exists
-	info, err = fs.Stat("file1")
-	req.EqualError(err, os.ErrNotExist.Error())
-	req.Nil(info)
+		var wg sync.WaitGroup
+
+		for i, name := range []string{"file1", "file2"} {
+			wg.Add(1)
+
+			go func(i int, name string) {
+				defer wg.Done()
+
+				read, err := afero.ReadFile(fs, name)
+
+				switch {
+				case err != nil:
+					errs <- err
+				case len(read) != len(content):
+					errs <- fmt.Errorf("read %d bytes of %s", len(read), name)
+				}
+
+				durations[i] = time.Since(start)
+			}(i, name)
+		}
+
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			req.NoError(err)
+		}
+
+		for _, d := range durations {
+			req.GreaterOrEqual(d, 750*time.Millisecond)
+		}
+	}
+
+	{ // Removing the limits
+		fs.SetRateLimits(0, 0)
+
+		start := time.Now()
+
+		_, err := afero.ReadFile(fs, "file1")
+		req.NoError(err)
+		req.Less(time.Since(start), 375*time.Millisecond)
+	}
 }
 
-func TestStatDir(t *testing.T) {
+func TestResumableUpload(t *testing.T) {
 	fs, req := setup(t)
 
-	req.NoError(fs.Mkdir("dir1", 0))
+	fs.uploadChunkSize = 4
 
-	dir1, err := fs.Open("dir1")
-	req.NoError(err)
+	store := &FileUploadStateStore{Fs: afero.NewMemMapFs(), Dir: "states"}
+	fs.SetUploadStateStore(store)
 
-	info, errStat := dir1.Stat()
-	req.NoError(errStat)
+	content := []byte("0123456789abcdef")
 
-	req.True(info.IsDir())
+	key := func(name string) string {
+		p, err := fs.realPath("upload", name)
+		req.NoError(err)
+
+		return strings.ToLower(p)
+	}
+
+	interrupt := func(name, sourceID string) *UploadState {
+		_, err := fs.ResumableUpload(name, &interruptedSource{Reader: bytes.NewReader(content), limit: 8}, sourceID, nil)
+		req.True(errors.Is(err, errSourceInterrupted))
+
+		_, err = fs.Stat(name)
+		req.True(os.IsNotExist(err))
+
+		state, err := store.Load(key(name))
+		req.NoError(err)
+		req.NotNil(state)
+		req.EqualValues(8, state.Offset)
+		req.Equal(sourceID, state.SourceID)
+
+		return state
+	}
+
+	check := func(name string, expected []byte) {
+		read, err := afero.ReadFile(fs, name)
+		req.NoError(err)
+		req.Equal(expected, read)
+
+		state, err := store.Load(key(name))
+		req.NoError(err)
+		req.Nil(state)
+	}
+
+	{ // Resuming where the upload stopped
+		interrupt("file1", "v1")
+
+		var events []Progress
+
+		fs.SetProgressFunc(func(progress Progress) { events = append(events, progress) })
+
+		info, err := fs.ResumableUpload("file1", bytes.NewReader(content), "v1", nil)
+		req.NoError(err)
+		req.EqualValues(len(content), info.Size())
+		check("file1", content)
+
+		fs.SetProgressFunc(nil)
+
+		req.NotEmpty(events)
+		req.EqualValues(12, events[0].Transferred)
+		req.EqualValues(len(content), events[len(events)-1].Transferred)
+	}
+
+	{ // Dropbox collected more than the saved offset
+		state := interrupt("file2", "v1")
+		state.Offset = 4
+		req.NoError(store.Save(key("file2"), state))
+
+		_, err := fs.ResumableUpload("file2", bytes.NewReader(content), "v1", nil)
+		req.NoError(err)
+		check("file2", content)
+	}
+
+	{ // The source changed
+		interrupt("file3", "v1")
+
+		changed := []byte("the source changed")
+
+		_, err := fs.ResumableUpload("file3", bytes.NewReader(changed), "v2", nil)
+		req.NoError(err)
+		check("file3", changed)
+	}
+
+	{ // The session expired
+		req.NoError(store.Save(key("file4"), &UploadState{SessionID: "expired", Offset: 8, SourceID: "v1"}))
+
+		_, err := fs.ResumableUpload("file4", bytes.NewReader(content), "v1", nil)
+		req.NoError(err)
+		check("file4", content)
+	}
+
+	{ // Write modes
+		_, err := fs.ResumableUpload("file4", bytes.NewReader(content), "v1", &WriteOptions{Mode: WriteModeAdd})
+		req.True(os.IsExist(err))
+
+		_, err = fs.ResumableUpload("file4", bytes.NewReader(content), "v1", &WriteOptions{Mode: WriteModeUpdate, Rev: "0"})
+		req.True(errors.Is(err, ErrConflict))
+	}
+
+	{ // Without any store, the uploads still work
+		fs.SetUploadStateStore(nil)
+
+		_, err := fs.ResumableUpload("file5", bytes.NewReader(content), "v1", nil)
+		req.NoError(err)
+		check("file5", content)
+	}
 }
 
 func TestFileWrite(t *testing.T) {
@@ -219,7 +1767,7 @@ func TestBasic(t *testing.T) {
 
 	req.Equal("dropbox", fs.Name())
 	req.EqualError(fs.Chmod("file1", 0777), ErrNotSupported.Error())
-	req.EqualError(fs.Chtimes("file1", time.Now(), time.Now()), ErrNotSupported.Error())
+	req.True(os.IsNotExist(fs.Chtimes("file1", time.Now(), time.Now())))
 	req.EqualError(fs.Chown("file1", 1, 1), ErrNotSupported.Error())
 	req.NoError(f.Sync())
 	req.EqualError(f.Truncate(10), ErrNotSupported.Error())
@@ -233,7 +1781,7 @@ func TestDirList(t *testing.T) {
 	req.NoError(fs.Mkdir("dir2", 0))
 
 	for i := 0; i < 5; i++ {
-		f, err := fs.OpenFile(fmt.Sprintf("dir1/file_%d.txt", i), os.O_WRONLY, 0)
+		f, err := fs.OpenFile(fmt.Sprintf("dir1/file_%d.txt", i), os.O_WRONLY|os.O_CREATE, 0)
 		req.NoError(err)
 
 		_, err = f.WriteString(fmt.Sprintf("content %d", i))
@@ -285,7 +1833,7 @@ func TestFileSeekBasic(t *testing.T) {
 	fs, req := setup(t)
 
 	{ // Writing an initial file
-		file, err := fs.OpenFile("file1", os.O_WRONLY, 0777)
+		file, err := fs.OpenFile("file1", os.O_WRONLY|os.O_CREATE, 0777)
 		req.NoError(err)
 
 		_, err = file.WriteString("Hello world !")
@@ -353,6 +1901,18 @@ func TestFileSeekBasic(t *testing.T) {
 		req.Equal("rld !", string(buffer))
 	}
 
+	{ // Reading at an offset doesn't depend on the previous reads
+		for i := 0; i < 2; i++ {
+			n, err := file.ReadAt(buffer[:2], 3)
+			req.NoError(err)
+			req.Equal("lo", string(buffer[:n]))
+		}
+
+		n, err := file.ReadAt(buffer, 10)
+		req.Equal(io.EOF, err)
+		req.Equal("d !", string(buffer[:n]))
+	}
+
 	// Let's close it
 	req.NoError(file.Close())
 
@@ -360,3 +1920,65 @@ func TestFileSeekBasic(t *testing.T) {
 	_, err := file.Seek(10, io.SeekStart)
 	req.EqualError(err, "File is closed")
 }
+
+func TestSearch(t *testing.T) {
+	fs, req := setup(t)
+
+	req.NoError(fs.Mkdir("dir1", 0))
+
+	for _, name := range []string{"dir1/report.txt", "dir1/report.csv", "other.txt"} {
+		f, err := fs.OpenFile(name, os.O_WRONLY|os.O_CREATE, 0)
+		req.NoError(err)
+
+		_, err = f.WriteString("content")
+		req.NoError(err)
+
+		req.NoError(f.Close())
+	}
+
+	results, err := fs.Search(context.Background(), "report", &SearchOptions{
+		FilenameOnly:   true,
+		FileExtensions: []string{"txt"},
+	})
+	req.NoError(err)
+	req.Len(results, 1)
+	req.Equal("/dir1/report.txt", results[0].Path)
+	req.Equal("report.txt", results[0].Name())
+	req.False(results[0].IsDir())
+
+	results, err = fs.Search(context.Background(), "report", &SearchOptions{Path: "dir1", FilenameOnly: true})
+	req.NoError(err)
+	req.Len(results, 2)
+}
+
+func TestGlob(t *testing.T) {
+	fs, req := setup(t)
+
+	req.NoError(fs.Mkdir("dir1", 0))
+	req.NoError(fs.Mkdir("dir2", 0))
+
+	for _, name := range []string{"dir1/a.txt", "dir1/b.csv", "dir2/a.txt", "c.txt"} {
+		f, err := fs.OpenFile(name, os.O_WRONLY|os.O_CREATE, 0)
+		req.NoError(err)
+		req.NoError(f.Close())
+	}
+
+	matches, err := fs.Glob("c.txt")
+	req.NoError(err)
+	req.Equal([]string{"c.txt"}, matches)
+
+	matches, err = fs.Glob("dir1/*.txt")
+	req.NoError(err)
+	req.Equal([]string{"dir1/a.txt"}, matches)
+
+	matches, err = fs.Glob("/*/*.txt")
+	req.NoError(err)
+	req.Equal([]string{"/dir1/a.txt", "/dir2/a.txt"}, matches)
+
+	matches, err = fs.Glob("missing/*")
+	req.NoError(err)
+	req.Empty(matches)
+
+	_, err = fs.Glob("[")
+	req.Equal(path.ErrBadPattern, err)
+}
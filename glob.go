@@ -0,0 +1,179 @@
+package dropbox // nolint: golint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/dropbox/dropbox-sdk-go-unofficial/dropbox/files"
+)
+
+// Glob returns the names of all files matching pattern or nil if there is no matching file.
+// The syntax of patterns is the same as in path.Match.
+//
+// Unlike afero.Glob, which reads every directory level, it picks the cheapest
+// strategy for the pattern:
+// - a simple stat when the pattern doesn't contain any meta character
+// - a single folder listing when only the last element contains meta characters
+// - a search when only the last element is a literal name
+// - a single recursive listing in any other case
+func (fs *Fs) Glob(pattern string) ([]string, error) {
+	// We check the pattern is well-formed
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err // nolint: wrapcheck
+	}
+
+	absolute := strings.HasPrefix(pattern, "/")
+	elements := strings.Split(strings.Trim(path.Clean("/"+pattern), "/"), "/")
+
+	// The static part of the pattern is the directory we will be looking into
+	prefixLength := 0
+	for prefixLength < len(elements) && !hasMeta(elements[prefixLength]) {
+		prefixLength++
+	}
+
+	dir := "/" + strings.Join(elements[:prefixLength], "/")
+	fullPattern := path.Join("/", pattern)
+
+	var candidates []string
+
+	var err error
+
+	switch {
+	case prefixLength == len(elements):
+		candidates, err = fs.globStat(fullPattern)
+	case prefixLength == len(elements)-1:
+		candidates, err = fs.globList(dir, false)
+	case !hasMeta(elements[len(elements)-1]):
+		candidates, err = fs.globSearch(dir, elements[len(elements)-1])
+	default:
+		candidates, err = fs.globList(dir, true)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []string
+
+	for _, c := range candidates {
+		if ok, _ := path.Match(fullPattern, c); !ok {
+			continue
+		}
+
+		if !absolute {
+			c = strings.TrimPrefix(c, "/")
+		}
+
+		matches = append(matches, c)
+	}
+
+	sort.Strings(matches)
+
+	return matches, nil
+}
+
+func (fs *Fs) globStat(name string) ([]string, error) {
+	if _, err := fs.Stat(name); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return []string{name}, nil
+}
+
+func (fs *Fs) globList(dir string, recursive bool) ([]string, error) {
+	p, err := fs.realPath("glob", dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+
+	err = fs.listFolder(p, recursive, func(meta files.IsMetadata) {
+		if p := metadataPath(meta); p != "" {
+			names = append(names, fs.relativePath(p))
+		}
+	})
+
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return names, nil
+}
+
+func (fs *Fs) globSearch(dir, name string) ([]string, error) {
+	results, err := fs.Search(context.Background(), name, &SearchOptions{Path: dir, FilenameOnly: true})
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(results))
+	for _, r := range results {
+		names = append(names, r.Path)
+	}
+
+	return names, nil
+}
+
+// listFolder lists all the entries of a folder, following the cursors.
+func (fs *Fs) listFolder(p string, recursive bool, fn func(meta files.IsMetadata)) error {
+	// The API expects an empty path for the root
+	if p == "/" {
+		p = ""
+	}
+
+	res, err := fs.files.ListFolder(&files.ListFolderArg{
+		Path:                  p,
+		Recursive:             recursive,
+		IncludePropertyGroups: fs.propertyGroupsFilter(),
+	})
+
+	for {
+		if err != nil {
+			var errListFolder files.ListFolderAPIError
+			if errors.As(err, &errListFolder) && strings.HasPrefix(errListFolder.ErrorSummary, "path/not_found/") {
+				return os.ErrNotExist
+			}
+
+			return fmt.Errorf("couldn't fetch files list: %w", err)
+		}
+
+		for _, m := range res.Entries {
+			fn(m)
+		}
+
+		if !res.HasMore {
+			return nil
+		}
+
+		res, err = fs.files.ListFolderContinue(&files.ListFolderContinueArg{Cursor: res.Cursor})
+	}
+}
+
+func metadataPath(meta files.IsMetadata) string {
+	switch m := meta.(type) {
+	case *files.FileMetadata:
+		return m.PathDisplay
+	case *files.FolderMetadata:
+		return m.PathDisplay
+	default:
+		return ""
+	}
+}
+
+func hasMeta(s string) bool {
+	return strings.ContainsAny(s, `*?[\`)
+}
@@ -0,0 +1,47 @@
+package dropbox // nolint: golint
+
+import (
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// NormalizePath converts a name to the form dropbox uses to store it:
+// backslashes are converted to slashes, duplicate slashes and "." elements are removed
+// and the unicode characters are NFC-normalized.
+// It returns an ErrInvalidName error when one of the elements ends with a space or a dot,
+// which dropbox doesn't accept.
+// The returned path always starts with a "/". ".." elements are kept as they are.
+func NormalizePath(name string) (string, error) {
+	name = norm.NFC.String(strings.ReplaceAll(name, `\`, "/"))
+
+	parts := strings.Split(name, "/")
+	kept := make([]string, 0, len(parts))
+
+	for _, part := range parts {
+		switch {
+		case part == "" || part == ".":
+			continue
+		case part == "..":
+		case strings.HasSuffix(part, " ") || strings.HasSuffix(part, "."):
+			return "", &os.PathError{Op: "normalize", Path: name, Err: ErrInvalidName}
+		}
+
+		kept = append(kept, part)
+	}
+
+	return "/" + strings.Join(kept, "/"), nil
+}
+
+// ComparablePath returns a normalized and lowercased version of a name.
+// Dropbox paths are case-insensitive, two names pointing to the same file have the same comparable path.
+func ComparablePath(name string) (string, error) {
+	p, err := NormalizePath(name)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.ToLower(path.Clean(p)), nil
+}
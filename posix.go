@@ -0,0 +1,302 @@
+package dropbox // nolint: golint
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/dropbox/dropbox-sdk-go-unofficial/dropbox"
+	"github.com/dropbox/dropbox-sdk-go-unofficial/dropbox/file_properties"
+	"github.com/dropbox/dropbox-sdk-go-unofficial/dropbox/files"
+)
+
+const (
+	posixTemplateName = "afero-dropbox posix metadata"
+	posixFieldMode    = "mode"
+	posixFieldUID     = "uid"
+	posixFieldGID     = "gid"
+	posixFieldAtime   = "atime"
+	posixFieldMtime   = "mtime"
+	posixModeMask     = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+)
+
+// PosixMetadata is the POSIX metadata of a file, it's returned by FileInfo.Sys when the emulation is enabled.
+// The fields that were never set have their zero value, HasMode, HasOwner and HasTimes tell them
+// apart from the ones set to zero.
+type PosixMetadata struct {
+	// Mode contains the permission bits, and the setuid, setgid and sticky bits
+	Mode  os.FileMode
+	UID   int
+	GID   int
+	Atime time.Time
+	Mtime time.Time
+	// Metadata is the dropbox metadata of the file
+	Metadata files.IsMetadata
+
+	present posixFields // fields stored in the properties
+	changed posixFields // fields set since the properties were read
+	stored  bool        // the properties group exists
+}
+
+// posixFields is a set of POSIX metadata fields.
+type posixFields uint8
+
+const (
+	posixHasMode posixFields = 1 << iota
+	posixHasOwner
+	posixHasAtime
+	posixHasMtime
+)
+
+// HasMode tells if the mode was set.
+func (p *PosixMetadata) HasMode() bool {
+	return p.present&posixHasMode != 0
+}
+
+// HasOwner tells if the owner was set.
+func (p *PosixMetadata) HasOwner() bool {
+	return p.present&posixHasOwner != 0
+}
+
+// HasTimes tells if the access and modification times were set.
+func (p *PosixMetadata) HasTimes() bool {
+	return p.present&(posixHasAtime|posixHasMtime) != 0
+}
+
+func (p *PosixMetadata) setMode(mode os.FileMode) {
+	p.Mode = mode & posixModeMask
+	p.present |= posixHasMode
+	p.changed |= posixHasMode
+}
+
+func (p *PosixMetadata) setOwner(uid, gid int) {
+	p.UID = uid
+	p.GID = gid
+	p.present |= posixHasOwner
+	p.changed |= posixHasOwner
+}
+
+func (p *PosixMetadata) setTimes(atime, mtime time.Time) {
+	p.Atime = atime
+	p.Mtime = mtime
+	p.present |= posixHasAtime | posixHasMtime
+	p.changed |= posixHasAtime | posixHasMtime
+}
+
+// EnablePosixMetadata enables the emulation of the POSIX metadata: Chmod, Chown and Chtimes store
+// the mode, owner and times of the files in dropbox file properties, and FileInfo returns them.
+// The properties template is owned by the app, it is created if it doesn't exist yet.
+// Files without any stored metadata keep the default mode and their dropbox modification time.
+func (fs *Fs) EnablePosixMetadata() error {
+	templates, err := fs.properties.TemplatesListForUser()
+	if err != nil {
+		return fmt.Errorf("couldn't list properties templates: %w", err)
+	}
+
+	for _, id := range templates.TemplateIds {
+		template, errGet := fs.properties.TemplatesGetForUser(&file_properties.GetTemplateArg{TemplateId: id})
+		if errGet != nil {
+			return fmt.Errorf("couldn't fetch properties template: %w", errGet)
+		}
+
+		if template.Name == posixTemplateName {
+			fs.setPosixTemplate(id)
+
+			return nil
+		}
+	}
+
+	fields := make([]*file_properties.PropertyFieldTemplate, 0, 5)
+
+	for _, name := range []string{posixFieldMode, posixFieldUID, posixFieldGID, posixFieldAtime, posixFieldMtime} {
+		fields = append(fields, &file_properties.PropertyFieldTemplate{
+			Name:        name,
+			Description: "POSIX " + name,
+			Type:        &file_properties.PropertyType{Tagged: dropbox.Tagged{Tag: file_properties.PropertyTypeString}},
+		})
+	}
+
+	res, err := fs.properties.TemplatesAddForUser(file_properties.NewAddTemplateArg(
+		posixTemplateName, "POSIX metadata of the files stored by afero-dropbox", fields,
+	))
+	if err != nil {
+		return fmt.Errorf("couldn't create properties template: %w", err)
+	}
+
+	fs.setPosixTemplate(res.TemplateId)
+
+	return nil
+}
+
+func (fs *Fs) setPosixTemplate(id string) {
+	fs.settings.Lock()
+	defer fs.settings.Unlock()
+
+	fs.posixTemplate = id
+}
+
+// posixTemplateID returns the properties template storing the POSIX metadata, if its emulation is enabled.
+func (fs *Fs) posixTemplateID() string {
+	fs.settings.RLock()
+	defer fs.settings.RUnlock()
+
+	return fs.posixTemplate
+}
+
+// propertyGroupsFilter returns the filter fetching the POSIX metadata, if the emulation is enabled.
+func (fs *Fs) propertyGroupsFilter() *file_properties.TemplateFilterBase {
+	template := fs.posixTemplateID()
+	if template == "" {
+		return nil
+	}
+
+	return &file_properties.TemplateFilterBase{
+		Tagged:     dropbox.Tagged{Tag: file_properties.TemplateFilterBaseFilterSome},
+		FilterSome: []string{template},
+	}
+}
+
+// posixMetadata extracts the POSIX metadata from the properties of a file.
+// It returns nil if the emulation is disabled.
+func (fs *Fs) posixMetadata(meta files.IsMetadata) *PosixMetadata {
+	template := fs.posixTemplateID()
+	if template == "" {
+		return nil
+	}
+
+	var groups []*file_properties.PropertyGroup
+
+	switch m := meta.(type) {
+	case *files.FileMetadata:
+		groups = m.PropertyGroups
+	case *files.FolderMetadata:
+		groups = m.PropertyGroups
+	}
+
+	posix := &PosixMetadata{Metadata: meta}
+
+	for _, group := range groups {
+		if group.TemplateId != template {
+			continue
+		}
+
+		posix.stored = true
+
+		for _, field := range group.Fields {
+			posix.parseField(field.Name, field.Value)
+		}
+	}
+
+	return posix
+}
+
+// parseField reads a stored field, the invalid ones are ignored.
+func (p *PosixMetadata) parseField(name, value string) {
+	var err error
+
+	switch name {
+	case posixFieldMode:
+		var mode uint64
+		if mode, err = strconv.ParseUint(value, 8, 32); err == nil {
+			p.setMode(os.FileMode(mode))
+		}
+	case posixFieldUID:
+		if p.UID, err = strconv.Atoi(value); err == nil {
+			p.present |= posixHasOwner
+		}
+	case posixFieldGID:
+		if p.GID, err = strconv.Atoi(value); err == nil {
+			p.present |= posixHasOwner
+		}
+	case posixFieldAtime:
+		if p.Atime, err = time.Parse(time.RFC3339Nano, value); err == nil {
+			p.present |= posixHasAtime
+		}
+	case posixFieldMtime:
+		if p.Mtime, err = time.Parse(time.RFC3339Nano, value); err == nil {
+			p.present |= posixHasMtime
+		}
+	}
+}
+
+// fields returns the property fields of a set of POSIX metadata fields.
+func (p *PosixMetadata) fields(set posixFields) []*file_properties.PropertyField {
+	fields := make([]*file_properties.PropertyField, 0, 5)
+
+	if set&posixHasMode != 0 {
+		fields = append(fields, file_properties.NewPropertyField(posixFieldMode, strconv.FormatUint(uint64(p.Mode), 8)))
+	}
+
+	if set&posixHasOwner != 0 {
+		fields = append(fields,
+			file_properties.NewPropertyField(posixFieldUID, strconv.Itoa(p.UID)),
+			file_properties.NewPropertyField(posixFieldGID, strconv.Itoa(p.GID)),
+		)
+	}
+
+	if set&posixHasAtime != 0 {
+		fields = append(fields, file_properties.NewPropertyField(posixFieldAtime, p.Atime.UTC().Format(time.RFC3339Nano)))
+	}
+
+	if set&posixHasMtime != 0 {
+		fields = append(fields, file_properties.NewPropertyField(posixFieldMtime, p.Mtime.UTC().Format(time.RFC3339Nano)))
+	}
+
+	return fields
+}
+
+// updatePosixMetadata changes the POSIX metadata of a file and stores it.
+func (fs *Fs) updatePosixMetadata(op, name string, update func(p *PosixMetadata)) error {
+	p, err := fs.realPath(op, name)
+	if err != nil {
+		return err
+	}
+
+	info, err := fs.stat(p)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+		}
+
+		return err
+	}
+
+	// The root folder can't have any property
+	if p == "/" || p == "" {
+		return &os.PathError{Op: op, Path: name, Err: ErrNotSupported}
+	}
+
+	posix := info.(*FileInfo).posix
+
+	update(posix)
+
+	if err = fs.storePosixMetadata(p, posix); err != nil {
+		return fmt.Errorf("couldn't store posix metadata: %w", err)
+	}
+
+	return nil
+}
+
+// storePosixMetadata stores the changed POSIX metadata fields of a file.
+// The other fields are left as they are, so that concurrent updates of them are kept.
+func (fs *Fs) storePosixMetadata(p string, posix *PosixMetadata) error {
+	fields := posix.fields(posix.changed)
+
+	if !posix.stored {
+		group := []*file_properties.PropertyGroup{file_properties.NewPropertyGroup(fs.posixTemplateID(), fields)}
+
+		// The group might have been added in the meantime, it's then updated
+		if err := fs.properties.PropertiesAdd(file_properties.NewAddPropertiesArg(p, group)); !isPropertyGroupConflict(err) {
+			return err // nolint: wrapcheck
+		}
+	}
+
+	group := file_properties.NewPropertyGroupUpdate(fs.posixTemplateID())
+	group.AddOrUpdateFields = fields
+
+	return fs.properties.PropertiesUpdate( // nolint: wrapcheck
+		file_properties.NewUpdatePropertiesArg(p, []*file_properties.PropertyGroupUpdate{group}),
+	)
+}
@@ -0,0 +1,87 @@
+package dropbox // nolint: golint
+
+import "time"
+
+// TransferDirection tells if a transfer is an upload or a download.
+type TransferDirection int
+
+const (
+	// TransferUpload is an upload to dropbox
+	TransferUpload TransferDirection = iota
+	// TransferDownload is a download from dropbox
+	TransferDownload
+)
+
+// Progress describes the progress of an upload or a download.
+type Progress struct {
+	// Name of the file, as returned by File.Name
+	Name string
+	// Direction of the transfer
+	Direction TransferDirection
+	// Transferred is the number of bytes transferred so far
+	Transferred int64
+	// Total is the number of bytes to transfer, or -1 if it isn't known
+	Total int64
+	// Rate is the average transfer rate, in bytes per second
+	Rate float64
+	// ChunkDone is set when a chunk of an upload session was accepted by dropbox
+	ChunkDone bool
+}
+
+// ProgressFunc receives the progress of the transfers.
+// It's called by the transfers themselves, so it should return quickly.
+type ProgressFunc func(progress Progress)
+
+// SetProgressFunc defines the function receiving the progress of all the transfers.
+// It can be overridden for each file with File.SetProgressFunc, a nil fn disables it.
+func (fs *Fs) SetProgressFunc(fn ProgressFunc) {
+	fs.settings.Lock()
+	defer fs.settings.Unlock()
+
+	fs.progress = fn
+}
+
+func (fs *Fs) progressFunc() ProgressFunc {
+	fs.settings.RLock()
+	defer fs.settings.RUnlock()
+
+	return fs.progress
+}
+
+// transferProgress tracks the progress of a transfer.
+type transferProgress struct {
+	progress Progress
+	start    time.Time
+	sent     int64 // bytes transferred since start, the rate is computed from them
+}
+
+func newTransferProgress(name string, direction TransferDirection, total int64) *transferProgress {
+	return &transferProgress{
+		progress: Progress{Name: name, Direction: direction, Total: total},
+		start:    time.Now(),
+	}
+}
+
+// resume sets the bytes transferred before, like the offset of a resumed upload.
+func (t *transferProgress) resume(offset int64) {
+	t.progress.Transferred = offset
+}
+
+// report adds n transferred bytes and sends the progress to fn, if it's set.
+func (t *transferProgress) report(fn ProgressFunc, n int64, chunkDone bool) {
+	t.progress.Transferred += n
+	t.sent += n
+
+	if fn == nil {
+		return
+	}
+
+	progress := t.progress
+	progress.ChunkDone = chunkDone
+
+	if elapsed := time.Since(t.start).Seconds(); elapsed > 0 {
+		progress.Rate = float64(t.sent) / elapsed
+	}
+
+	fn(progress)
+}
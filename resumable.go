@@ -0,0 +1,284 @@
+package dropbox // nolint: golint
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/dropbox/dropbox-sdk-go-unofficial/dropbox/files"
+	"github.com/spf13/afero"
+)
+
+// UploadState is the state of a resumable upload, it's saved after each uploaded chunk.
+type UploadState struct {
+	// SessionID is the dropbox upload session
+	SessionID string `json:"session_id"`
+	// Offset is the number of bytes of the source accepted by dropbox
+	Offset uint64 `json:"offset"`
+	// SourceID identifies the uploaded content, the upload isn't resumed if the source changed
+	SourceID string `json:"source_id"`
+}
+
+// UploadStateStore persists the states of the resumable uploads.
+// The keys are the lower case dropbox paths of the uploaded files.
+type UploadStateStore interface {
+	// Load returns the state of an upload, or nil if there's none
+	Load(key string) (*UploadState, error)
+	// Save stores the state of an upload
+	Save(key string, state *UploadState) error
+	// Delete removes the state of an upload, if there's one
+	Delete(key string) error
+}
+
+// SetUploadStateStore defines where ResumableUpload saves the state of the uploads.
+// A nil store disables the resumption of the uploads, this is the default.
+func (fs *Fs) SetUploadStateStore(store UploadStateStore) {
+	fs.settings.Lock()
+	defer fs.settings.Unlock()
+
+	fs.uploadStates = store
+}
+
+func (fs *Fs) uploadStateStore() UploadStateStore {
+	fs.settings.RLock()
+	defer fs.settings.RUnlock()
+
+	if fs.uploadStates == nil {
+		return nopUploadStateStore{}
+	}
+
+	return fs.uploadStates
+}
+
+// ResumableUpload uploads the content of source to a file through an upload session, saving its state
+// in the upload state store after each chunk. If the upload stops, for example because the process
+// was killed, uploading the same source to the same file again resumes it where it stopped.
+// sourceID identifies the content of source, like its path, size and modification time.
+func (fs *Fs) ResumableUpload(name string, source io.ReadSeeker, sourceID string, opts *WriteOptions) (os.FileInfo, error) {
+	p, err := fs.realPath("upload", name)
+	if err != nil {
+		return nil, err
+	}
+
+	key := strings.ToLower(p)
+	store := fs.uploadStateStore()
+
+	state, err := store.Load(key)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't load upload state: %w", err)
+	}
+
+	if state == nil || state.SourceID != sourceID {
+		state = &UploadState{SourceID: sourceID}
+	}
+
+	size, err := source.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't seek source: %w", err)
+	}
+
+	transfer := newTransferProgress(fs.relativePath(p), TransferUpload, size)
+	transfer.resume(int64(state.Offset))
+
+	commit := opts.commitInfo(p)
+	limiters := fs.rateLimiters(TransferUpload)
+	buffer := make([]byte, fs.uploadChunkSize)
+
+	for {
+		if _, err = source.Seek(int64(state.Offset), io.SeekStart); err != nil {
+			return nil, fmt.Errorf("couldn't seek source: %w", err)
+		}
+
+		n, errRead := io.ReadFull(throttle(source, limiters), buffer)
+		last := errors.Is(errRead, io.EOF) || errors.Is(errRead, io.ErrUnexpectedEOF)
+
+		if errRead != nil && !last {
+			return nil, fmt.Errorf("couldn't read source: %w", errRead)
+		}
+
+		meta, errUpload := fs.uploadChunk(commit, state, bytes.NewReader(buffer[:n]), last)
+		if errUpload != nil {
+			lookup := sessionLookupError(errUpload)
+
+			switch {
+			case lookup != nil && lookup.Tag == files.UploadSessionLookupErrorNotFound:
+				// The session expired, the upload has to start again
+				state = &UploadState{SourceID: sourceID}
+			case lookup != nil && lookup.IncorrectOffset != nil:
+				// Dropbox collected a different part of the source than we thought
+				correct := lookup.IncorrectOffset.CorrectOffset
+				if correct == state.Offset || correct > uint64(size) {
+					return nil, fmt.Errorf("couldn't resume upload: %w", errUpload)
+				}
+
+				state.Offset = correct
+			default:
+				return nil, fs.resumableUploadError(name, opts, errUpload)
+			}
+
+			transfer.resume(int64(state.Offset))
+
+			continue
+		}
+
+		if meta != nil {
+			if err = store.Delete(key); err != nil {
+				return nil, fmt.Errorf("couldn't delete upload state: %w", err)
+			}
+
+			transfer.report(fs.progressFunc(), int64(n), true)
+
+			return fs.newFileInfo(meta), nil
+		}
+
+		state.Offset += uint64(n)
+
+		if err = store.Save(key, state); err != nil {
+			return nil, fmt.Errorf("couldn't save upload state: %w", err)
+		}
+
+		transfer.report(fs.progressFunc(), int64(n), true)
+	}
+}
+
+// uploadChunk sends a chunk of a resumable upload, it returns the metadata of the file after the last one.
+func (fs *Fs) uploadChunk(commit *files.CommitInfo, state *UploadState, chunk io.Reader, last bool) (*files.FileMetadata, error) {
+	cursor := &files.UploadSessionCursor{SessionId: state.SessionID, Offset: state.Offset}
+
+	switch {
+	case state.SessionID == "" && last:
+		return fs.files.Upload(commit, chunk) // nolint: wrapcheck
+	case state.SessionID == "":
+		res, err := fs.files.UploadSessionStart(&files.UploadSessionStartArg{}, chunk)
+		if err != nil {
+			return nil, err // nolint: wrapcheck
+		}
+
+		state.SessionID = res.SessionId
+
+		return nil, nil
+	case last:
+		return fs.files.UploadSessionFinish(&files.UploadSessionFinishArg{Cursor: cursor, Commit: commit}, chunk) // nolint: wrapcheck
+	default:
+		return nil, fs.files.UploadSessionAppendV2(&files.UploadSessionAppendArg{Cursor: cursor}, chunk) // nolint: wrapcheck
+	}
+}
+
+func (fs *Fs) resumableUploadError(name string, opts *WriteOptions, err error) error {
+	if !isConflict(err) {
+		return fmt.Errorf("couldn't upload file: %w", err)
+	}
+
+	if opts != nil && opts.Mode == WriteModeUpdate {
+		return &os.PathError{Op: "upload", Path: name, Err: ErrConflict}
+	}
+
+	return &os.PathError{Op: "upload", Path: name, Err: os.ErrExist}
+}
+
+// sessionLookupError returns the reason why an upload session couldn't be used, if that's the error.
+func sessionLookupError(err error) *files.UploadSessionLookupError {
+	var errAppend files.UploadSessionAppendAPIError
+	if errors.As(err, &errAppend) {
+		return errAppend.EndpointError
+	}
+
+	var errFinish files.UploadSessionFinishAPIError
+	if errors.As(err, &errFinish) && errFinish.EndpointError != nil {
+		return errFinish.EndpointError.LookupFailed
+	}
+
+	return nil
+}
+
+// nopUploadStateStore doesn't save anything, the uploads can't be resumed.
+type nopUploadStateStore struct{}
+
+func (nopUploadStateStore) Load(string) (*UploadState, error) { return nil, nil }
+
+func (nopUploadStateStore) Save(string, *UploadState) error { return nil }
+
+func (nopUploadStateStore) Delete(string) error { return nil }
+
+// FileUploadStateStore keeps each upload state in a JSON file of a directory.
+type FileUploadStateStore struct {
+	// Fs is where the states are stored, the OS file system is used if it's not set
+	Fs afero.Fs
+	// Dir is the directory of Fs where the states are stored
+	Dir string
+}
+
+func (s *FileUploadStateStore) fs() afero.Fs {
+	if s.Fs == nil {
+		return afero.NewOsFs()
+	}
+
+	return s.Fs
+}
+
+func (s *FileUploadStateStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+
+	return path.Join(s.Dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// Load reads the state of an upload.
+func (s *FileUploadStateStore) Load(key string) (*UploadState, error) {
+	content, err := afero.ReadFile(s.fs(), s.path(key))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("couldn't read upload state: %w", err)
+	}
+
+	state := &UploadState{}
+	if err = json.Unmarshal(content, state); err != nil {
+		return nil, fmt.Errorf("couldn't parse upload state: %w", err)
+	}
+
+	return state, nil
+}
+
+// Save writes the state of an upload, it's replaced at once so that it's never partially written.
+func (s *FileUploadStateStore) Save(key string, state *UploadState) error {
+	content, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("couldn't serialize upload state: %w", err)
+	}
+
+	fs := s.fs()
+
+	if err = fs.MkdirAll(s.Dir, 0700); err != nil {
+		return fmt.Errorf("couldn't create upload states directory: %w", err)
+	}
+
+	name := s.path(key)
+
+	if err = afero.WriteFile(fs, name+".tmp", content, 0600); err != nil {
+		return fmt.Errorf("couldn't write upload state: %w", err)
+	}
+
+	if err = fs.Rename(name+".tmp", name); err != nil {
+		return fmt.Errorf("couldn't write upload state: %w", err)
+	}
+
+	return nil
+}
+
+// Delete removes the state of an upload.
+func (s *FileUploadStateStore) Delete(key string) error {
+	if err := s.fs().Remove(s.path(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("couldn't delete upload state: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package dropbox // nolint: golint
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/dropbox/dropbox-sdk-go-unofficial/dropbox"
+)
+
+// rpc performs a raw RPC call on the "files" namespace.
+// It is used for the routes that the SDK we depend on doesn't expose yet.
+func (fs *Fs) rpc(ctx context.Context, route string, arg interface{}, res interface{}) error {
+	body, err := json.Marshal(arg)
+	if err != nil {
+		return fmt.Errorf("couldn't encode request: %w", err)
+	}
+
+	headers := map[string]string{"Content-Type": "application/json"}
+
+	req, err := fs.api.NewRequest("api", "rpc", true, "files", route, headers, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("couldn't create request: %w", err)
+	}
+
+	resp, err := fs.api.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("couldn't perform request: %w", err)
+	}
+
+	defer func() { _ = resp.Body.Close() }()
+
+	if body, err = ioutil.ReadAll(resp.Body); err != nil {
+		return fmt.Errorf("couldn't read response: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusOK {
+		if err = json.Unmarshal(body, res); err != nil {
+			return fmt.Errorf("couldn't decode response: %w", err)
+		}
+
+		return nil
+	}
+
+	if resp.StatusCode == http.StatusConflict {
+		var apiError dropbox.APIError
+		if err = json.Unmarshal(body, &apiError); err != nil {
+			return fmt.Errorf("couldn't decode error: %w", err)
+		}
+
+		return apiError
+	}
+
+	return dropbox.HandleCommonAPIErrors(fs.conf, resp, body)
+}
@@ -0,0 +1,160 @@
+package dropbox // nolint: golint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/dropbox/dropbox-sdk-go-unofficial/dropbox"
+	"github.com/dropbox/dropbox-sdk-go-unofficial/dropbox/files"
+)
+
+// File categories that can be used to filter searches.
+const (
+	SearchCategoryImage        = "image"
+	SearchCategoryDocument     = "document"
+	SearchCategoryPDF          = "pdf"
+	SearchCategorySpreadsheet  = "spreadsheet"
+	SearchCategoryPresentation = "presentation"
+	SearchCategoryAudio        = "audio"
+	SearchCategoryVideo        = "video"
+	SearchCategoryFolder       = "folder"
+	SearchCategoryPaper        = "paper"
+	SearchCategoryOthers       = "others"
+)
+
+const searchMaxResults = 1000
+
+// SearchOptions allows to filter the results of a search.
+type SearchOptions struct {
+	// Path restricts the search to a directory, relative to the root directory.
+	Path string
+	// FileExtensions restricts the search to some file extensions (without the dot).
+	FileExtensions []string
+	// FileCategories restricts the search to some file categories (SearchCategory*).
+	FileCategories []string
+	// FilenameOnly disables the search on the files content.
+	FilenameOnly bool
+	// MaxResults limits the number of returned results, 0 means no limit.
+	MaxResults int
+}
+
+// SearchResult is a file found by a search.
+type SearchResult struct {
+	os.FileInfo
+	// Path of the file relative to the root directory
+	Path string
+}
+
+type searchV2Options struct {
+	Path           string           `json:"path,omitempty"`
+	MaxResults     uint64           `json:"max_results,omitempty"`
+	FilenameOnly   bool             `json:"filename_only"`
+	FileExtensions []string         `json:"file_extensions,omitempty"`
+	FileCategories []dropbox.Tagged `json:"file_categories,omitempty"`
+}
+
+type searchV2Arg struct {
+	Query   string          `json:"query"`
+	Options searchV2Options `json:"options"`
+}
+
+type searchV2ContinueArg struct {
+	Cursor string `json:"cursor"`
+}
+
+type searchV2Match struct {
+	Metadata struct {
+		dropbox.Tagged
+		Metadata json.RawMessage `json:"metadata"`
+	} `json:"metadata"`
+}
+
+type searchV2Result struct {
+	Matches []searchV2Match `json:"matches"`
+	HasMore bool            `json:"has_more"`
+	Cursor  string          `json:"cursor"`
+}
+
+// Search finds files by name, and optionally by content, using the dropbox search API.
+func (fs *Fs) Search(ctx context.Context, query string, opts *SearchOptions) ([]*SearchResult, error) {
+	if opts == nil {
+		opts = &SearchOptions{}
+	}
+
+	p, err := fs.realPath("search", opts.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	arg := &searchV2Arg{
+		Query: query,
+		Options: searchV2Options{
+			Path:           p,
+			MaxResults:     searchMaxResults,
+			FilenameOnly:   opts.FilenameOnly,
+			FileExtensions: opts.FileExtensions,
+		},
+	}
+
+	// The API doesn't accept "/" as a path
+	if arg.Options.Path == "/" {
+		arg.Options.Path = ""
+	}
+
+	if opts.MaxResults > 0 && opts.MaxResults < searchMaxResults {
+		arg.Options.MaxResults = uint64(opts.MaxResults)
+	}
+
+	for _, c := range opts.FileCategories {
+		arg.Options.FileCategories = append(arg.Options.FileCategories, dropbox.Tagged{Tag: c})
+	}
+
+	var results []*SearchResult
+
+	res := &searchV2Result{}
+	if err := fs.rpc(ctx, "search_v2", arg, res); err != nil {
+		return nil, fmt.Errorf("couldn't search: %w", err)
+	}
+
+	for {
+		for _, m := range res.Matches {
+			meta, err := files.IsMetadataFromJSON(m.Metadata.Metadata)
+			if err != nil {
+				return nil, fmt.Errorf("couldn't decode search match: %w", err)
+			}
+
+			if info := fs.newSearchResult(meta); info != nil {
+				results = append(results, info)
+			}
+
+			if opts.MaxResults > 0 && len(results) >= opts.MaxResults {
+				return results, nil
+			}
+		}
+
+		if !res.HasMore {
+			return results, nil
+		}
+
+		cursor := res.Cursor
+		res = &searchV2Result{}
+
+		if err := fs.rpc(ctx, "search/continue_v2", &searchV2ContinueArg{Cursor: cursor}, res); err != nil {
+			return nil, fmt.Errorf("couldn't continue search: %w", err)
+		}
+	}
+}
+
+func (fs *Fs) newSearchResult(meta files.IsMetadata) *SearchResult {
+	displayPath := metadataPath(meta)
+	if displayPath == "" {
+		return nil
+	}
+
+	return &SearchResult{
+		FileInfo: fs.newFileInfo(meta),
+		Path:     fs.relativePath(displayPath),
+	}
+}
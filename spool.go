@@ -0,0 +1,405 @@
+package dropbox // nolint: golint
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/dropbox/dropbox-sdk-go-unofficial/dropbox/files"
+	"github.com/spf13/afero"
+)
+
+// SpoolOptions enables the read-write (O_RDWR) and append (O_APPEND) handles.
+// These handles download the file to a local spool, serve all the operations
+// locally and upload it back on Sync and Close.
+type SpoolOptions struct {
+	// Fs is where the spooled files are stored, the OS temporary directory is used if it's not set
+	Fs afero.Fs
+	// Dir is the directory of Fs where the spooled files are stored
+	Dir string
+	// MaxSize is the maximum size of a spooled file, 0 means no limit
+	MaxSize int64
+}
+
+// SetSpool enables the read-write and append handles with a local spool.
+// A nil opts disables them, this is the default.
+func (fs *Fs) SetSpool(opts *SpoolOptions) {
+	fs.settings.Lock()
+	defer fs.settings.Unlock()
+
+	fs.spool = opts
+}
+
+func (fs *Fs) spoolOptions() *SpoolOptions {
+	fs.settings.RLock()
+	defer fs.settings.RUnlock()
+
+	return fs.spool
+}
+
+// spoolFile is a file handle backed by a local copy of the file.
+// Like File, it's safe for concurrent use.
+type spoolFile struct {
+	mu     sync.Mutex
+	fs     *Fs
+	name   string
+	local  afero.File
+	spool  *SpoolOptions
+	append bool
+	dirty  bool
+	closed bool
+	rev    string
+	info   os.FileInfo
+}
+
+func (fs *Fs) openSpoolFile(name, p string, flag int, spool *SpoolOptions) (afero.File, error) {
+	spoolFs := spool.Fs
+	if spoolFs == nil {
+		spoolFs = afero.NewOsFs()
+	}
+
+	f := &spoolFile{
+		fs:     fs,
+		name:   p,
+		spool:  spool,
+		append: flag&os.O_APPEND != 0,
+	}
+
+	if err := f.load(name, flag); err != nil {
+		return nil, err
+	}
+
+	local, err := afero.TempFile(spoolFs, spool.Dir, "afero-dropbox-")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create spool file: %w", err)
+	}
+
+	f.local = local
+
+	if !f.dirty {
+		if err = f.download(); err != nil {
+			_ = f.removeLocal()
+
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+// load checks the file can be opened with the given flags and fetches its current revision.
+func (f *spoolFile) load(name string, flag int) error {
+	info, err := f.fs.stat(f.name)
+
+	switch {
+	case err == nil && info.IsDir():
+		return &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	case err == nil && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	case err == nil:
+		f.info = info
+		f.rev = info.(*FileInfo).Rev()
+
+		if f.spool.MaxSize > 0 && info.Size() > f.spool.MaxSize {
+			return &os.PathError{Op: "open", Path: name, Err: ErrSpoolTooLarge}
+		}
+
+		// A truncated file doesn't need to be downloaded
+		f.dirty = flag&os.O_TRUNC != 0
+	case errors.Is(err, os.ErrNotExist) && flag&os.O_CREATE == 0:
+		return &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	case errors.Is(err, os.ErrNotExist):
+		f.dirty = true
+	default:
+		return err
+	}
+
+	return nil
+}
+
+func (f *spoolFile) download() error {
+	meta, content, err := f.fs.files.Download(&files.DownloadArg{Path: f.name, Rev: f.rev})
+	if err != nil {
+		return fmt.Errorf("couldn't download file: %w", err)
+	}
+
+	defer func() { _ = content.Close() }()
+
+	if _, err = io.Copy(f.local, throttle(content, f.fs.rateLimiters(TransferDownload))); err != nil {
+		return fmt.Errorf("couldn't spool file: %w", err)
+	}
+
+	f.info = f.fs.newFileInfo(meta)
+	f.rev = meta.Rev
+
+	if _, err = f.local.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("couldn't rewind spool file: %w", err)
+	}
+
+	return nil
+}
+
+// upload commits the local content, as long as the file wasn't modified by someone else.
+func (f *spoolFile) upload() error {
+	info, err := f.local.Stat()
+	if err != nil {
+		return fmt.Errorf("couldn't stat spool file: %w", err)
+	}
+
+	opts := &WriteOptions{Mode: WriteModeUpdate, Rev: f.rev}
+	if f.rev == "" {
+		opts = &WriteOptions{Mode: WriteModeAdd}
+	}
+
+	commit := opts.commitInfo(f.name)
+
+	content := throttle(io.NewSectionReader(f.local, 0, info.Size()), f.fs.rateLimiters(TransferUpload))
+
+	transfer := newTransferProgress(f.Name(), TransferUpload, info.Size())
+	onChunk := func(n int64) { transfer.report(f.fs.progressFunc(), n, true) }
+
+	// Large files need an upload session
+	meta, err := f.fs.uploadSession(commit, content, onChunk)
+	if err != nil {
+		if isConflict(err) {
+			if f.rev == "" {
+				return &os.PathError{Op: "write", Path: f.Name(), Err: os.ErrExist}
+			}
+
+			return &os.PathError{Op: "write", Path: f.Name(), Err: ErrConflict}
+		}
+
+		return fmt.Errorf("couldn't upload file: %w", err)
+	}
+
+	f.info = f.fs.newFileInfo(meta)
+	f.rev = meta.Rev
+	f.dirty = false
+
+	return nil
+}
+
+func (f *spoolFile) removeLocal() error {
+	name := f.local.Name()
+
+	if err := f.local.Close(); err != nil {
+		return fmt.Errorf("couldn't close spool file: %w", err)
+	}
+
+	spoolFs := f.spool.Fs
+	if spoolFs == nil {
+		spoolFs = afero.NewOsFs()
+	}
+
+	if err := spoolFs.Remove(name); err != nil {
+		return fmt.Errorf("couldn't remove spool file: %w", err)
+	}
+
+	return nil
+}
+
+func (f *spoolFile) checkSize(size int64) error {
+	if f.spool.MaxSize > 0 && size > f.spool.MaxSize {
+		return &os.PathError{Op: "write", Path: f.Name(), Err: ErrSpoolTooLarge}
+	}
+
+	return nil
+}
+
+// Close uploads the file if it was modified and removes the local copy.
+// The other operations fail with the errors of the closed local copy after that.
+func (f *spoolFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return afero.ErrFileClosed
+	}
+
+	f.closed = true
+
+	var err error
+
+	if f.dirty {
+		err = f.upload()
+	}
+
+	if errRemove := f.removeLocal(); err == nil {
+		err = errRemove
+	}
+
+	return err
+}
+
+// Read reads from the local copy.
+func (f *spoolFile) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.local.Read(p)
+}
+
+// ReadAt reads from the local copy.
+func (f *spoolFile) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.local.ReadAt(p, off)
+}
+
+// Seek seeks in the local copy.
+func (f *spoolFile) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.local.Seek(offset, whence)
+}
+
+// Write writes to the local copy, at the end of it if the file was opened with O_APPEND.
+func (f *spoolFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var offset int64
+
+	var err error
+
+	if f.append {
+		offset, err = f.local.Seek(0, io.SeekEnd)
+	} else {
+		offset, err = f.local.Seek(0, io.SeekCurrent)
+	}
+
+	if err != nil {
+		return 0, err // nolint: wrapcheck
+	}
+
+	if err = f.checkSize(offset + int64(len(p))); err != nil {
+		return 0, err
+	}
+
+	f.dirty = true
+
+	return f.local.Write(p)
+}
+
+// WriteAt writes to the local copy.
+func (f *spoolFile) WriteAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.append {
+		return 0, &os.PathError{Op: "writeat", Path: f.Name(), Err: ErrNotSupported}
+	}
+
+	if err := f.checkSize(off + int64(len(p))); err != nil {
+		return 0, err
+	}
+
+	f.dirty = true
+
+	return f.local.WriteAt(p, off)
+}
+
+// WriteString writes a string to the local copy.
+func (f *spoolFile) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+// Truncate truncates the local copy.
+func (f *spoolFile) Truncate(size int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.checkSize(size); err != nil {
+		return err
+	}
+
+	f.dirty = true
+
+	return f.local.Truncate(size)
+}
+
+// Sync uploads the file if it was modified.
+func (f *spoolFile) Sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return afero.ErrFileClosed
+	}
+
+	if !f.dirty {
+		return nil
+	}
+
+	return f.upload()
+}
+
+// Name returns the file name, relative to the root directory of the Fs.
+func (f *spoolFile) Name() string {
+	return f.fs.relativePath(f.name)
+}
+
+// Readdir isn't supported on files.
+func (f *spoolFile) Readdir(int) ([]os.FileInfo, error) {
+	return nil, &os.PathError{Op: "readdir", Path: f.Name(), Err: syscall.ENOTDIR}
+}
+
+// Readdirnames isn't supported on files.
+func (f *spoolFile) Readdirnames(int) ([]string, error) {
+	return nil, &os.PathError{Op: "readdirnames", Path: f.Name(), Err: syscall.ENOTDIR}
+}
+
+// Stat describes the local copy of the file.
+func (f *spoolFile) Stat() (os.FileInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	local, err := f.local.Stat()
+	if err != nil {
+		return nil, err // nolint: wrapcheck
+	}
+
+	return &spoolFileInfo{FileInfo: local, name: path.Base(f.Name()), remote: f.info}, nil
+}
+
+// spoolFileInfo describes a spooled file, with its local size and modification time.
+type spoolFileInfo struct {
+	os.FileInfo
+	name   string
+	remote os.FileInfo
+}
+
+// Name returns the file name.
+func (i *spoolFileInfo) Name() string {
+	return i.name
+}
+
+// Mode returns the file mode.
+func (i *spoolFileInfo) Mode() os.FileMode {
+	return simulatedFileMode
+}
+
+// ModTime returns the modification time of the local copy if it was modified.
+func (i *spoolFileInfo) ModTime() time.Time {
+	if i.remote != nil && !i.FileInfo.ModTime().After(i.remote.ModTime()) {
+		return i.remote.ModTime()
+	}
+
+	return i.FileInfo.ModTime()
+}
+
+// Sys returns the dropbox metadata of the file, if it exists in dropbox.
+func (i *spoolFileInfo) Sys() interface{} {
+	if i.remote != nil {
+		return i.remote.Sys()
+	}
+
+	return nil
+}
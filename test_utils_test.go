@@ -8,6 +8,7 @@ import (
 	"os"
 	"testing"
 
+	"github.com/dropbox/dropbox-sdk-go-unofficial/dropbox/files"
 	"github.com/spf13/afero"
 )
 
@@ -19,7 +20,7 @@ func testWriteFile(t *testing.T, fs afero.Fs, name string, size int) {
 		t.Log("  Writing file")
 		reader1 := NewLimitedReader(rand.New(rand.NewSource(0)), size)
 
-		file, errOpen := fs.OpenFile(name, os.O_WRONLY, 0777)
+		file, errOpen := fs.OpenFile(name, os.O_WRONLY|os.O_CREATE, 0777)
 		if errOpen != nil {
 			t.Fatal("Could not open file:", errOpen)
 		}
@@ -111,3 +112,66 @@ func ReadersEqual(r1, r2 io.Reader) (bool, error) {
 		}
 	}
 }
+
+var errBatchRefused = errors.New("batch refused")
+
+// limitedBatchClient accepts a limited number of batch requests, the next ones fail.
+type limitedBatchClient struct {
+	files.Client
+	batches int
+}
+
+func (c *limitedBatchClient) accept() error {
+	if c.batches == 0 {
+		return errBatchRefused
+	}
+
+	c.batches--
+
+	return nil
+}
+
+func (c *limitedBatchClient) DeleteBatch(arg *files.DeleteBatchArg) (*files.DeleteBatchLaunch, error) {
+	if err := c.accept(); err != nil {
+		return nil, err
+	}
+
+	return c.Client.DeleteBatch(arg)
+}
+
+func (c *limitedBatchClient) MoveBatchV2(arg *files.MoveBatchArg) (*files.RelocationBatchV2Launch, error) {
+	if err := c.accept(); err != nil {
+		return nil, err
+	}
+
+	return c.Client.MoveBatchV2(arg)
+}
+
+func (c *limitedBatchClient) CopyBatchV2(arg *files.RelocationBatchArgBase) (*files.RelocationBatchV2Launch, error) {
+	if err := c.accept(); err != nil {
+		return nil, err
+	}
+
+	return c.Client.CopyBatchV2(arg)
+}
+
+var errSourceInterrupted = errors.New("source interrupted")
+
+// interruptedSource is a source that fails when it's read beyond some offset, like a killed process.
+type interruptedSource struct {
+	*bytes.Reader
+	limit int64
+}
+
+func (s *interruptedSource) Read(p []byte) (int, error) {
+	pos, _ := s.Seek(0, io.SeekCurrent)
+	if pos >= s.limit {
+		return 0, errSourceInterrupted
+	}
+
+	if int64(len(p)) > s.limit-pos {
+		p = p[:s.limit-pos]
+	}
+
+	return s.Reader.Read(p)
+}
@@ -0,0 +1,111 @@
+package dropbox // nolint: golint
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// throttleQuantum is the largest transfer reserved at once on a rate limiter, it keeps the concurrent
+	// transfers sharing a limiter interleaved
+	throttleQuantum = 32 << 10
+)
+
+// rateLimiter limits a transfer rate. The reservations are served in order, so the
+// transfers sharing it get a fair share of the bandwidth.
+type rateLimiter struct {
+	mu   sync.Mutex
+	rate float64   // bytes per second
+	next time.Time // end of the last reservation
+}
+
+func newRateLimiter(bytesPerSecond int64) *rateLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+
+	return &rateLimiter{rate: float64(bytesPerSecond)}
+}
+
+// reserve books the transfer of n bytes and returns how long to wait before doing it.
+func (l *rateLimiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+
+	start := l.next
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+
+	return start.Sub(now)
+}
+
+// waitRate blocks until n bytes can be transferred on all the limiters.
+func waitRate(limiters []*rateLimiter, n int) {
+	for _, l := range limiters {
+		if delay := l.reserve(n); delay > 0 {
+			time.Sleep(delay)
+		}
+	}
+}
+
+// throttledReader is a reader limited by some rate limiters.
+type throttledReader struct {
+	reader   io.Reader
+	limiters []*rateLimiter
+}
+
+// throttle limits the rate of a reader, it's returned as is without any limiter.
+func throttle(reader io.Reader, limiters []*rateLimiter) io.Reader {
+	if len(limiters) == 0 {
+		return reader
+	}
+
+	return &throttledReader{reader: reader, limiters: limiters}
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleQuantum {
+		p = p[:throttleQuantum]
+	}
+
+	n, err := r.reader.Read(p)
+	waitRate(r.limiters, n)
+
+	return n, err // nolint: wrapcheck
+}
+
+// SetRateLimits limits the read (download) and write (upload) rates of all the transfers, in bytes per second.
+// The concurrent transfers share these limits fairly, 0 means no limit, this is the default.
+func (fs *Fs) SetRateLimits(read, write int64) {
+	fs.settings.Lock()
+	defer fs.settings.Unlock()
+
+	fs.readLimiter = newRateLimiter(read)
+	fs.writeLimiter = newRateLimiter(write)
+}
+
+// rateLimiters returns the limiters of a transfer direction, along with some extra ones.
+func (fs *Fs) rateLimiters(direction TransferDirection, extra ...*rateLimiter) []*rateLimiter {
+	fs.settings.RLock()
+	defer fs.settings.RUnlock()
+
+	limiter := fs.writeLimiter
+	if direction == TransferDownload {
+		limiter = fs.readLimiter
+	}
+
+	limiters := make([]*rateLimiter, 0, len(extra)+1)
+
+	for _, l := range append(extra, limiter) {
+		if l != nil {
+			limiters = append(limiters, l)
+		}
+	}
+
+	return limiters
+}
@@ -0,0 +1,90 @@
+package dropbox // nolint: golint
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+
+	"github.com/dropbox/dropbox-sdk-go-unofficial/dropbox/files"
+)
+
+// Truncate changes the size of a file.
+// Dropbox doesn't support it, so the file is rewritten: with nothing when size is 0, and otherwise with
+// the first size bytes of its current revision, padded with zeros when it grows.
+// The rewrite fails with ErrConflict if the file was modified in the meantime.
+func (fs *Fs) Truncate(name string, size int64) error {
+	p, err := fs.realPath("truncate", name)
+	if err != nil {
+		return err
+	}
+
+	if size < 0 {
+		return &os.PathError{Op: "truncate", Path: name, Err: os.ErrInvalid}
+	}
+
+	info, err := fs.stat(p)
+
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return &os.PathError{Op: "truncate", Path: name, Err: os.ErrNotExist}
+	case err != nil:
+		return err
+	case info.IsDir():
+		return &os.PathError{Op: "truncate", Path: name, Err: syscall.EISDIR}
+	}
+
+	meta, _ := info.(*FileInfo).meta.(*files.FileMetadata)
+
+	// Nothing to rewrite
+	if info.Size() == size {
+		return nil
+	}
+
+	var content io.Reader = bytes.NewReader(nil)
+
+	if size > 0 {
+		download, body, errDownload := fs.files.Download(&files.DownloadArg{Path: p, Rev: meta.Rev})
+		if errDownload != nil {
+			return fmt.Errorf("couldn't download file: %w", errDownload)
+		}
+
+		defer func() { _ = body.Close() }()
+
+		downloaded := throttle(body, fs.rateLimiters(TransferDownload))
+		content = io.LimitReader(downloaded, size)
+
+		if size > int64(download.Size) {
+			content = io.MultiReader(downloaded, io.LimitReader(zeroReader{}, size-int64(download.Size)))
+		}
+	}
+
+	opts := &WriteOptions{Mode: WriteModeUpdate, Rev: meta.Rev}
+
+	transfer := newTransferProgress(fs.relativePath(p), TransferUpload, size)
+	onChunk := func(n int64) { transfer.report(fs.progressFunc(), n, true) }
+
+	_, err = fs.uploadSession(opts.commitInfo(p), throttle(content, fs.rateLimiters(TransferUpload)), onChunk)
+	if err != nil {
+		if isConflict(err) {
+			return &os.PathError{Op: "truncate", Path: name, Err: ErrConflict}
+		}
+
+		return fmt.Errorf("couldn't upload file: %w", err)
+	}
+
+	return nil
+}
+
+// zeroReader reads an infinite amount of zeros.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+
+	return len(p), nil
+}
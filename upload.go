@@ -0,0 +1,72 @@
+package dropbox // nolint: golint
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/dropbox/dropbox-sdk-go-unofficial/dropbox/files"
+)
+
+const (
+	// uploadSessionChunkSize is the size of the chunks sent by upload sessions, dropbox accepts up to 150MiB
+	uploadSessionChunkSize = 16 << 20
+)
+
+// uploadSession uploads some content through an upload session, in chunks of fs.uploadChunkSize.
+// Contents that fit in a single chunk are uploaded with a single request.
+// onChunk, if it's set, is called with the size of each chunk accepted by dropbox.
+func (fs *Fs) uploadSession(
+	commit *files.CommitInfo, content io.Reader, onChunk func(n int64),
+) (*files.FileMetadata, error) {
+	buffer := make([]byte, fs.uploadChunkSize)
+	cursor := &files.UploadSessionCursor{}
+
+	for {
+		n, err := io.ReadFull(content, buffer)
+		last := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+
+		if err != nil && !last {
+			return nil, fmt.Errorf("couldn't read content: %w", err)
+		}
+
+		chunk := bytes.NewReader(buffer[:n])
+
+		if last {
+			var meta *files.FileMetadata
+
+			if cursor.SessionId == "" {
+				meta, err = fs.files.Upload(commit, chunk)
+			} else {
+				meta, err = fs.files.UploadSessionFinish(&files.UploadSessionFinishArg{Cursor: cursor, Commit: commit}, chunk)
+			}
+
+			if err == nil && onChunk != nil {
+				onChunk(int64(n))
+			}
+
+			return meta, err // nolint: wrapcheck
+		}
+
+		switch {
+		case cursor.SessionId == "":
+			res, errStart := fs.files.UploadSessionStart(&files.UploadSessionStartArg{}, chunk)
+			if errStart != nil {
+				return nil, fmt.Errorf("couldn't start upload session: %w", errStart)
+			}
+
+			cursor.SessionId = res.SessionId
+		default:
+			if errAppend := fs.files.UploadSessionAppendV2(&files.UploadSessionAppendArg{Cursor: cursor}, chunk); errAppend != nil {
+				return nil, fmt.Errorf("couldn't append to upload session: %w", errAppend)
+			}
+		}
+
+		cursor.Offset += uint64(n)
+
+		if onChunk != nil {
+			onChunk(int64(n))
+		}
+	}
+}
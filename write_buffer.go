@@ -0,0 +1,155 @@
+package dropbox // nolint: golint
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/spf13/afero"
+)
+
+const (
+	// writeBufferMemoryLimit is the size above which a write buffer is spilled to a temporary file
+	writeBufferMemoryLimit = 8 << 20
+)
+
+// writeBuffer holds the content of a file written out of order.
+// It's kept in memory until it grows beyond the memory limit, and in a temporary file after that.
+type writeBuffer struct {
+	spillFs  afero.Fs
+	spillDir string
+	limit    int64
+	memory   []byte
+	spill    afero.File
+	size     int64
+	written  bool
+}
+
+// newWriteBuffer creates a write buffer that spills to the spool if one is set, or to the OS temporary directory.
+func (fs *Fs) newWriteBuffer() *writeBuffer {
+	b := &writeBuffer{spillFs: afero.NewOsFs(), limit: fs.writeBufferMemory}
+
+	if spool := fs.spoolOptions(); spool != nil && spool.Fs != nil {
+		b.spillFs = spool.Fs
+		b.spillDir = spool.Dir
+	}
+
+	return b
+}
+
+// Size returns the size of the content.
+func (b *writeBuffer) Size() int64 {
+	return b.size
+}
+
+// WriteAt writes at any offset, the gaps are filled with zeros.
+func (b *writeBuffer) WriteAt(p []byte, off int64) (int, error) {
+	end := off + int64(len(p))
+
+	if b.spill == nil && end > b.limit {
+		if err := b.spillToFile(); err != nil {
+			return 0, err
+		}
+	}
+
+	b.written = true
+
+	if end > b.size {
+		if err := b.Truncate(end); err != nil {
+			return 0, err
+		}
+	}
+
+	if b.spill != nil {
+		return b.spill.WriteAt(p, off) // nolint: wrapcheck
+	}
+
+	return copy(b.memory[off:], p), nil
+}
+
+// ReadAt reads the content, it's used to upload it.
+func (b *writeBuffer) ReadAt(p []byte, off int64) (int, error) {
+	if off >= b.size {
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > b.size-off {
+		p = p[:b.size-off]
+	}
+
+	if b.spill != nil {
+		return b.spill.ReadAt(p, off) // nolint: wrapcheck
+	}
+
+	return copy(p, b.memory[off:]), nil
+}
+
+// Truncate changes the size of the content.
+func (b *writeBuffer) Truncate(size int64) error {
+	if b.spill == nil && size > b.limit {
+		if err := b.spillToFile(); err != nil {
+			return err
+		}
+	}
+
+	b.written = true
+	b.size = size
+
+	if b.spill != nil {
+		return b.spill.Truncate(size) // nolint: wrapcheck
+	}
+
+	if size <= int64(len(b.memory)) {
+		b.memory = b.memory[:size]
+	} else {
+		b.memory = append(b.memory, make([]byte, size-int64(len(b.memory)))...)
+	}
+
+	return nil
+}
+
+// Reader returns a reader of the whole content.
+func (b *writeBuffer) Reader() io.Reader {
+	return io.NewSectionReader(b, 0, b.size)
+}
+
+func (b *writeBuffer) spillToFile() error {
+	spill, err := afero.TempFile(b.spillFs, b.spillDir, "afero-dropbox-")
+	if err != nil {
+		return fmt.Errorf("couldn't create spill file: %w", err)
+	}
+
+	if _, err = spill.WriteAt(b.memory, 0); err != nil {
+		_ = spill.Close()
+		_ = b.spillFs.Remove(spill.Name())
+
+		return fmt.Errorf("couldn't write spill file: %w", err)
+	}
+
+	b.spill = spill
+	b.memory = nil
+
+	return nil
+}
+
+// Close releases the content, and removes the spill file if there's one.
+func (b *writeBuffer) Close() error {
+	b.memory = nil
+
+	if b.spill == nil {
+		return nil
+	}
+
+	name := b.spill.Name()
+
+	if err := b.spill.Close(); err != nil {
+		return fmt.Errorf("couldn't close spill file: %w", err)
+	}
+
+	b.spill = nil
+
+	if err := b.spillFs.Remove(name); err != nil {
+		return fmt.Errorf("couldn't remove spill file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+package dropbox // nolint: golint
+
+import (
+	"os"
+	"time"
+
+	"github.com/dropbox/dropbox-sdk-go-unofficial/dropbox"
+	"github.com/dropbox/dropbox-sdk-go-unofficial/dropbox/files"
+	"github.com/spf13/afero"
+)
+
+// WriteMode defines what happens when an uploaded file conflicts with an existing one.
+type WriteMode int
+
+const (
+	// WriteModeOverwrite replaces the existing file. This is the default.
+	WriteModeOverwrite WriteMode = iota
+	// WriteModeAdd fails with os.ErrExist if the file already exists.
+	WriteModeAdd
+	// WriteModeAddRename stores the file under a different name if the file already exists.
+	// The actual name is returned by File.Name() and File.Stat() once the file is closed.
+	WriteModeAddRename
+	// WriteModeUpdate replaces the existing file only if its revision is still WriteOptions.Rev,
+	// and fails with ErrConflict otherwise.
+	WriteModeUpdate
+)
+
+// WriteOptions defines how a file opened for writing is uploaded.
+type WriteOptions struct {
+	// Mode defines what happens when the file conflicts with an existing one
+	Mode WriteMode
+	// Rev is the revision the file is expected to have, it's only used by WriteModeUpdate
+	Rev string
+	// Seekable allows Seek, WriteAt and Truncate on the file, writes can then happen in any order.
+	// The content is buffered, in memory and then in a temporary file (in the spool if one is set),
+	// and uploaded when the file is closed.
+	Seekable bool
+	// ModTime is the modification time of the file, the upload time is used if it's not set.
+	// Dropbox only keeps the seconds.
+	ModTime time.Time
+}
+
+// OpenFileWithOptions opens a file like OpenFile, with some options applied when it's opened for writing.
+func (fs *Fs) OpenFileWithOptions(name string, flag int, perm os.FileMode, opts *WriteOptions) (afero.File, error) {
+	return fs.openFile(name, flag, perm, opts)
+}
+
+func (o *WriteOptions) commitInfo(p string) *files.CommitInfo {
+	info := &files.CommitInfo{
+		Path: p,
+		// Dropbox API has a BUG. TODO: Report it
+		//ClientModified: time.Now().UTC(),
+		Mode:       &files.WriteMode{Tagged: dropbox.Tagged{Tag: files.WriteModeOverwrite}},
+		Autorename: false,
+	}
+
+	if o == nil {
+		return info
+	}
+
+	if !o.ModTime.IsZero() {
+		info.ClientModified = o.ModTime.UTC().Truncate(time.Second)
+	}
+
+	switch o.Mode {
+	case WriteModeOverwrite:
+	case WriteModeAdd:
+		info.Mode.Tag = files.WriteModeAdd
+		info.StrictConflict = true
+	case WriteModeAddRename:
+		info.Mode.Tag = files.WriteModeAdd
+		info.StrictConflict = true
+		info.Autorename = true
+	case WriteModeUpdate:
+		info.Mode.Tag = files.WriteModeUpdate
+		info.Mode.Update = o.Rev
+		info.StrictConflict = true
+	}
+
+	return info
+}